@@ -1,9 +1,18 @@
 package check
 
 import (
+	"genspark2api/common/config"
 	logger "genspark2api/common/loggger"
 )
 
 func CheckEnvVariable() {
+	// 检查 TOKEN_OPERATION_PASSWORD 是否设置, 默认密码需显式允许
+	if config.TokenOperationPassword == "" || config.TokenOperationPassword == "admin" {
+		if !config.AllowDefaultPassword {
+			logger.FatalLog("TOKEN_OPERATION_PASSWORD 未设置或为默认密码 admin, 请设置强密码; 如确需使用默认密码, 请设置 ALLOW_DEFAULT_PASSWORD=true")
+		}
+		logger.SysLog("警告: 使用默认管理密码: admin")
+		config.TokenOperationPassword = "admin"
+	}
 	logger.SysLog("Environment variable check passed.")
 }
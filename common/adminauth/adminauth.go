@@ -0,0 +1,177 @@
+package adminauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CookieName 管理后台会话 cookie 名
+const CookieName = "gs_admin_session"
+
+var (
+	secretOnce sync.Once
+	secret     []byte
+
+	revokedMutex sync.Mutex
+	// revoked 已注销但未过期的会话, 值为过期时间
+	revoked = make(map[string]time.Time)
+
+	failureMutex sync.Mutex
+	failures     = make(map[string]*loginFailure)
+	// globalFailures 所有客户端的登录失败次数, globalWindowStart 为当前统计窗口的开始时间
+	globalFailures    loginFailure
+	globalWindowStart time.Time
+)
+
+type loginFailure struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// sessionSecret 返回会话签名密钥, 未配置 ADMIN_SESSION_SECRET 时生成进程级随机密钥
+func sessionSecret() []byte {
+	secretOnce.Do(func() {
+		if config.AdminSessionSecret != "" {
+			secret = []byte(config.AdminSessionSecret)
+			return
+		}
+		logger.SysLog("ADMIN_SESSION_SECRET 未设置, 使用随机密钥, 重启后需重新登录管理后台")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	})
+	return secret
+}
+
+func sign(parts ...string) string {
+	mac := hmac.New(sha256.New, sessionSecret())
+	mac.Write([]byte(strings.Join(parts, ".")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CheckPassword 以固定时间比较管理密码, 避免通过响应时间猜测密码
+func CheckPassword(password string) bool {
+	expected := sha256.Sum256([]byte(config.TokenOperationPassword))
+	actual := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(expected[:], actual[:]) == 1
+}
+
+// IssueSession 签发会话令牌, 格式为 <过期时间>.<随机数>.<签名>
+func IssueSession() (token string, expiresAt time.Time, err error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt = time.Now().Add(time.Duration(config.AdminSessionTTL) * time.Second)
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	nonceStr := hex.EncodeToString(nonce)
+	return expires + "." + nonceStr + "." + sign(expires, nonceStr), expiresAt, nil
+}
+
+// VerifySession 校验会话令牌的签名、有效期及是否已注销
+func VerifySession(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(parts[0], parts[1]))) {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	revokedMutex.Lock()
+	defer revokedMutex.Unlock()
+	_, isRevoked := revoked[parts[1]]
+	return !isRevoked
+}
+
+// Revoke 注销会话令牌
+func Revoke(token string) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return
+	}
+	expires, _ := strconv.ParseInt(parts[0], 10, 64)
+	now := time.Now()
+
+	revokedMutex.Lock()
+	defer revokedMutex.Unlock()
+	for nonce, expiresAt := range revoked {
+		if now.After(expiresAt) {
+			delete(revoked, nonce)
+		}
+	}
+	revoked[parts[1]] = time.Unix(expires, 0)
+}
+
+// CSRFToken 返回与会话绑定的 CSRF 令牌
+func CSRFToken(token string) string {
+	return sign("csrf", token)
+}
+
+// CheckCSRF 校验 CSRF 令牌
+func CheckCSRF(token string, csrfToken string) bool {
+	return csrfToken != "" && hmac.Equal([]byte(csrfToken), []byte(CSRFToken(token)))
+}
+
+// LockedFor 返回 key(客户端IP)剩余的锁定时间, 全局锁定时所有 key 均被锁定, 未锁定时返回 0
+func LockedFor(key string) time.Duration {
+	failureMutex.Lock()
+	defer failureMutex.Unlock()
+	remaining := time.Until(globalFailures.lockedUntil)
+	if f, ok := failures[key]; ok {
+		remaining = max(remaining, time.Until(f.lockedUntil))
+	}
+	return max(remaining, 0)
+}
+
+// RecordFailure 记录一次登录失败, 连续失败达到上限后锁定该 key;
+// 锁定时间内所有 key 的失败总数达到上限后锁定全部登录
+func RecordFailure(key string) {
+	failureMutex.Lock()
+	defer failureMutex.Unlock()
+	now := time.Now()
+	lockout := time.Duration(config.AdminLockoutSeconds) * time.Second
+	if now.Sub(globalWindowStart) > lockout {
+		globalWindowStart = now
+		globalFailures.count = 0
+	}
+	globalFailures.count++
+	if config.AdminMaxGlobalLoginFailures > 0 && globalFailures.count >= config.AdminMaxGlobalLoginFailures {
+		globalFailures.count = 0
+		globalFailures.lockedUntil = now.Add(lockout)
+		logger.SysLog("管理后台登录失败次数过多, 已暂停所有登录")
+	}
+
+	f, ok := failures[key]
+	if !ok {
+		f = &loginFailure{}
+		failures[key] = f
+	}
+	f.count++
+	if f.count >= config.AdminMaxLoginFailures {
+		f.count = 0
+		f.lockedUntil = now.Add(lockout)
+		logger.SysLog("管理后台登录连续失败, 已锁定: " + key)
+	}
+}
+
+// RecordSuccess 登录成功后清除失败记录
+func RecordSuccess(key string) {
+	failureMutex.Lock()
+	defer failureMutex.Unlock()
+	delete(failures, key)
+}
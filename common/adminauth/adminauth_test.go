@@ -0,0 +1,220 @@
+package adminauth
+
+import (
+	"genspark2api/common/config"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetFailures 清除登录失败记录, 并在测试结束后恢复锁定相关配置
+func resetFailures(t *testing.T, maxFailures, maxGlobalFailures int) {
+	previous := []int{config.AdminMaxLoginFailures, config.AdminMaxGlobalLoginFailures, config.AdminLockoutSeconds}
+	config.AdminMaxLoginFailures, config.AdminMaxGlobalLoginFailures, config.AdminLockoutSeconds = maxFailures, maxGlobalFailures, 60
+	reset := func() {
+		failureMutex.Lock()
+		defer failureMutex.Unlock()
+		failures = make(map[string]*loginFailure)
+		globalFailures = loginFailure{}
+		globalWindowStart = time.Time{}
+	}
+	reset()
+	t.Cleanup(func() {
+		reset()
+		config.AdminMaxLoginFailures, config.AdminMaxGlobalLoginFailures, config.AdminLockoutSeconds = previous[0], previous[1], previous[2]
+	})
+}
+
+func TestCheckPassword(t *testing.T) {
+	previous := config.TokenOperationPassword
+	config.TokenOperationPassword = "s3cret"
+	defer func() { config.TokenOperationPassword = previous }()
+
+	for password, want := range map[string]bool{"s3cret": true, "s3cre": false, "": false, "S3CRET": false} {
+		if got := CheckPassword(password); got != want {
+			t.Errorf("CheckPassword(%q) = %v, want %v", password, got, want)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	token, expiresAt, err := IssueSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expiresAt); until <= 0 || until > time.Duration(config.AdminSessionTTL)*time.Second {
+		t.Fatalf("session expires in %s, want within ADMIN_SESSION_TTL", until)
+	}
+	if !VerifySession(token) {
+		t.Fatal("VerifySession rejected a fresh session")
+	}
+	other, _, err := IssueSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == token {
+		t.Fatal("IssueSession returned the same token twice")
+	}
+
+	parts := strings.Split(token, ".")
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"missing signature", parts[0] + "." + parts[1]},
+		{"extra part", token + ".x"},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))},
+		// 修改过期时间或随机数后签名不再匹配
+		{"extended expiry", strconv.FormatInt(time.Now().Add(time.Hour*24*365).Unix(), 10) + "." + parts[1] + "." + parts[2]},
+		{"changed nonce", parts[0] + "." + strings.Repeat("0", len(parts[1])) + "." + parts[2]},
+		{"expired", expired + "." + parts[1] + "." + sign(expired, parts[1])},
+		{"non-numeric expiry", "soon." + parts[1] + "." + sign("soon", parts[1])},
+	}
+	for _, tt := range tests {
+		if VerifySession(tt.token) {
+			t.Errorf("%s: VerifySession(%q) = true", tt.name, tt.token)
+		}
+	}
+
+	// 注销只影响该会话
+	Revoke(token)
+	if VerifySession(token) {
+		t.Fatal("VerifySession accepted a revoked session")
+	}
+	if !VerifySession(other) {
+		t.Fatal("revoking one session revoked another")
+	}
+	Revoke("not-a-token")
+	if !VerifySession(other) {
+		t.Fatal("revoking a malformed token revoked a session")
+	}
+}
+
+func TestRevokePrunesExpired(t *testing.T) {
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	Revoke(expired + ".stale." + sign(expired, "stale"))
+	token, _, err := IssueSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Revoke(token)
+
+	revokedMutex.Lock()
+	defer revokedMutex.Unlock()
+	if _, ok := revoked["stale"]; ok {
+		t.Fatal("expired revocation was not pruned")
+	}
+	if _, ok := revoked[strings.Split(token, ".")[1]]; !ok {
+		t.Fatal("revocation was not recorded")
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	token, _, err := IssueSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := IssueSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrf := CSRFToken(token)
+	if csrf == "" || csrf == CSRFToken(other) {
+		t.Fatalf("CSRFToken not bound to the session: %q", csrf)
+	}
+
+	tests := []struct {
+		name    string
+		session string
+		csrf    string
+		want    bool
+	}{
+		{"valid", token, csrf, true},
+		{"empty", token, "", false},
+		{"other session", other, csrf, false},
+		{"session as token", token, token, false},
+		{"truncated", token, csrf[:len(csrf)-1], false},
+	}
+	for _, tt := range tests {
+		if got := CheckCSRF(tt.session, tt.csrf); got != tt.want {
+			t.Errorf("%s: CheckCSRF = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLockoutPerIP(t *testing.T) {
+	resetFailures(t, 3, 0)
+
+	for i := 0; i < 2; i++ {
+		RecordFailure("10.0.0.1")
+	}
+	if d := LockedFor("10.0.0.1"); d != 0 {
+		t.Fatalf("locked for %s after 2 failures, want 0", d)
+	}
+	// 登录成功后重新计数
+	RecordSuccess("10.0.0.1")
+	for i := 0; i < 2; i++ {
+		RecordFailure("10.0.0.1")
+	}
+	if d := LockedFor("10.0.0.1"); d != 0 {
+		t.Fatalf("locked for %s after success and 2 failures, want 0", d)
+	}
+
+	RecordFailure("10.0.0.1")
+	if d := LockedFor("10.0.0.1"); d <= 0 || d > time.Minute {
+		t.Fatalf("locked for %s after 3 failures, want up to ADMIN_LOCKOUT_SECONDS", d)
+	}
+	if d := LockedFor("10.0.0.2"); d != 0 {
+		t.Fatalf("other IP locked for %s", d)
+	}
+}
+
+func TestLockoutGlobal(t *testing.T) {
+	resetFailures(t, 100, 5)
+
+	// 每个 IP 的失败次数都未达到上限, 总数达到上限后锁定所有登录
+	for i := 0; i < 4; i++ {
+		RecordFailure("10.0.1." + strconv.Itoa(i))
+	}
+	if d := LockedFor("10.0.2.1"); d != 0 {
+		t.Fatalf("locked for %s after 4 global failures, want 0", d)
+	}
+	RecordFailure("10.0.1.4")
+	for _, ip := range []string{"10.0.1.0", "10.0.2.1"} {
+		if d := LockedFor(ip); d <= 0 || d > time.Minute {
+			t.Fatalf("%s locked for %s after 5 global failures, want up to ADMIN_LOCKOUT_SECONDS", ip, d)
+		}
+	}
+	// 登录成功不解除全局锁定
+	RecordSuccess("10.0.2.1")
+	if d := LockedFor("10.0.2.1"); d <= 0 {
+		t.Fatal("RecordSuccess cleared the global lockout")
+	}
+}
+
+func TestLockoutGlobalWindow(t *testing.T) {
+	resetFailures(t, 100, 3)
+
+	// 统计窗口已过期的失败不计入总数
+	RecordFailure("10.0.3.1")
+	RecordFailure("10.0.3.2")
+	failureMutex.Lock()
+	globalWindowStart = time.Now().Add(-2 * time.Minute)
+	failureMutex.Unlock()
+	RecordFailure("10.0.3.3")
+	if d := LockedFor("10.0.3.4"); d != 0 {
+		t.Fatalf("locked for %s with failures from an expired window", d)
+	}
+
+	// 0 为不限制
+	config.AdminMaxGlobalLoginFailures = 0
+	for i := 0; i < 10; i++ {
+		RecordFailure("10.0.4." + strconv.Itoa(i))
+	}
+	if d := LockedFor("10.0.4.10"); d != 0 {
+		t.Fatalf("locked for %s with ADMIN_MAX_GLOBAL_LOGIN_FAILURES=0", d)
+	}
+}
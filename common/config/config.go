@@ -13,7 +13,34 @@ var (
     ApiSecret = os.Getenv("API_SECRET")
     ApiSecrets = strings.Split(os.Getenv("API_SECRET"), ",")
     TokenOperationPassword = os.Getenv("TOKEN_OPERATION_PASSWORD")
-    GSCookies []string
+    // 显式允许使用默认管理密码 admin 启动
+    AllowDefaultPassword = env.Bool("ALLOW_DEFAULT_PASSWORD", false)
+    // 管理后台会话签名密钥, 为空时使用随机密钥(重启后需重新登录)
+    AdminSessionSecret = os.Getenv("ADMIN_SESSION_SECRET")
+    AdminSessionTTL = env.Int("ADMIN_SESSION_TTL", 12 * 60 * 60)
+    // 连续登录失败次数达到上限后锁定的时间(秒)
+    AdminMaxLoginFailures = env.Int("ADMIN_MAX_LOGIN_FAILURES", 5)
+    AdminLockoutSeconds = env.Int("ADMIN_LOCKOUT_SECONDS", 15 * 60)
+    // 锁定时间内所有客户端登录失败的总次数上限, 达到后暂停所有登录, 防止更换IP绕过锁定
+    AdminMaxGlobalLoginFailures = env.Int("ADMIN_MAX_GLOBAL_LOGIN_FAILURES", 50)
+    // 信任的反向代理(IP或CIDR, 以,分隔), 只有来自这些地址的 X-Forwarded-For 才会用于识别客户端IP
+    TrustedProxies = env.StringSlice("TRUSTED_PROXIES", nil)
+    // cookie 来源: GS_COOKIE(以,分隔)、GS_COOKIE_FILE(Docker secrets 等文件, 每行一个)、TOKEN_DIR(目录下的所有文件)
+    GSCookies = env.StringSlice("GS_COOKIE", nil)
+    GSCookieFile = os.Getenv("GS_COOKIE_FILE")
+    TokenDir = os.Getenv("TOKEN_DIR")
+    // TokenFilePath token 文件路径, 未配置时优先使用 Docker 镜像中的路径
+    TokenFilePath = os.Getenv("TOKEN_FILE_PATH")
+    TokenWatchInterval = env.Int("TOKEN_WATCH_INTERVAL", 2)
+    // token 存储后端: file, sqlite, redis
+    TokenStore = env.String("TOKEN_STORE", "file")
+    TokenSQLitePath = env.String("TOKEN_SQLITE_PATH", "genspark2api.db")
+    TokenRedisURL = env.String("TOKEN_REDIS_URL", "redis://localhost:6379/0")
+    TokenRedisKey = env.String("TOKEN_REDIS_KEY", "genspark2api:tokens")
+    // token 加密密钥, 也可通过 TOKEN_ENCRYPTION_KEY_FILE 从文件读取, 为空时不加密
+    TokenEncryptionKey = os.Getenv("TOKEN_ENCRYPTION_KEY")
+    // 轮换前使用过的旧密钥, 仅用于解密
+    TokenEncryptionOldKeys = env.StringSlice("TOKEN_ENCRYPTION_OLD_KEYS", nil)
     AutoDelChat = env.Int("AUTO_DEL_CHAT", 0)
     AllDialogRecordEnable = os.Getenv("ALL_DIALOG_RECORD_ENABLE")
     RequestOutTime = os.Getenv("REQUEST_OUT_TIME")
@@ -25,38 +52,87 @@ var (
     RequestOutTimeDuration = 5 * time.Minute
     RequestRateLimitNum = env.Int("REQUEST_RATE_LIMIT", 60)
     RequestRateLimitDuration int64 = 1 * 60
+    // 本地文件存储
+    LocalStorageEnable = env.Bool("LOCAL_STORAGE_ENABLE", false)
+    FileBaseURL = os.Getenv("FILE_BASE_URL")
+    FileSignSecret = os.Getenv("FILE_SIGN_SECRET")
+    FileURLExpireSeconds = env.Int("FILE_URL_EXPIRE", 24 * 60 * 60)
+    FileRetentionHours = env.Int("FILE_RETENTION_HOURS", 7 * 24)
+    // Files API 文件记录存储路径
+    FileStorePath = env.String("FILE_STORE_PATH", "files.json")
+    // 附件上传缓存
+    UploadCacheEnable = env.Bool("UPLOAD_CACHE_ENABLE", true)
+    UploadCacheTTL = env.Int("UPLOAD_CACHE_TTL", 60 * 60)
+    UploadCacheMaxEntries = env.Int("UPLOAD_CACHE_MAX_ENTRIES", 1000)
+    UploadCacheMaxBytes = env.Int("UPLOAD_CACHE_MAX_BYTES", 256 * 1024 * 1024)
+    // 附件并发处理
+    AttachmentConcurrency = env.Int("ATTACHMENT_CONCURRENCY", 4)
+    AttachmentTimeout = env.Int("ATTACHMENT_TIMEOUT", 60)
+    // 远程附件下载限制
+    FetchAllowedSchemes = env.StringSlice("FETCH_ALLOWED_SCHEMES", []string{"http", "https"})
+    FetchAllowDomains = env.StringSlice("FETCH_ALLOW_DOMAINS", nil)
+    FetchDenyDomains = env.StringSlice("FETCH_DENY_DOMAINS", nil)
+    FetchAllowPrivate = env.Bool("FETCH_ALLOW_PRIVATE", false)
+    FetchMaxBytes = env.Int("FETCH_MAX_BYTES", 20 * 1024 * 1024)
+    FetchTimeout = env.Int("FETCH_TIMEOUT", 30)
+    // 图片预处理
+    ImagePreprocessEnable = env.Bool("IMAGE_PREPROCESS_ENABLE", true)
+    ImageMaxDimension = env.Int("IMAGE_MAX_DIMENSION", 2048)
+    ImageJpegQuality = env.Int("IMAGE_JPEG_QUALITY", 85)
+    // 等待生图任务完成的最长时间(秒)
+    ImageTaskTimeout = env.Int("IMAGE_TASK_TIMEOUT", 300)
+    // cookie 健康检查
+    CookieCooldownSeconds = env.Int("COOKIE_COOLDOWN", 60)
+    CookieMaxFailures = env.Int("COOKIE_MAX_FAILURES", 5)
+    CookieMaxAuthFailures = env.Int("COOKIE_MAX_AUTH_FAILURES", 3)
+    CookieProbeInterval = env.Int("COOKIE_PROBE_INTERVAL", 10 * 60)
+    // 主动校验所有 cookie 的间隔(秒), 0为不校验
+    CookieCheckInterval = env.Int("COOKIE_CHECK_INTERVAL", 60 * 60)
+    // cookie 选择策略: random, round_robin, least_in_flight, weighted, sticky
+    CookieSelectStrategy = env.String("COOKIE_SELECT_STRATEGY", "random")
+    CookieWeights = env.StringSlice("COOKIE_WEIGHTS", nil)
+    // 每个 cookie 同时处理的最大请求数, 0为不限制; 全部达到上限时请求排队等待
+    CookieMaxInFlight = env.Int("COOKIE_MAX_IN_FLIGHT", 3)
+    QueueMaxSize = env.Int("QUEUE_MAX_SIZE", 100)
+    QueueTimeout = env.Int("QUEUE_TIMEOUT", 60)
+    // 全局上游代理, 支持 http/https/socks5, 未给 cookie 单独绑定代理时使用
+    ProxyURL = os.Getenv("PROXY_URL")
+    ProxyCheckInterval = env.Int("PROXY_CHECK_INTERVAL", 5 * 60)
+    ProxyMaxFailures = env.Int("PROXY_MAX_FAILURES", 3)
+    // 全局浏览器指纹配置名称, 未给 cookie 单独绑定时使用
+    BrowserProfile = env.String("BROWSER_PROFILE", "chrome_131_mac")
+    // 自定义浏览器指纹配置文件(JSON)
+    BrowserProfilesFile = os.Getenv("BROWSER_PROFILES_FILE")
+    // genspark 上游地址, 可指向本地服务用于测试
+    GensparkBaseURL = strings.TrimRight(env.String("GENSPARK_BASE_URL", "https://www.genspark.ai"), "/")
+    // 上游空闲连接的保留时间(秒), 同一指纹和代理的请求复用连接
+    UpstreamIdleTimeout = env.Int("UPSTREAM_IDLE_TIMEOUT", 90)
+    // 退出时等待进行中请求完成的最长时间(秒)
+    ShutdownTimeout = env.Int("SHUTDOWN_TIMEOUT", 30)
+    // 根据上游响应的 Set-Cookie 自动刷新并保存 cookie
+    CookieAutoRefresh = env.Bool("COOKIE_AUTO_REFRESH", true)
 )
 
 func init() {
-    // 指定 token.txt 的路径（与 Dockerfile 中的路径保持一致）
-    tokenPath := "/app/genspark2api/data/token.txt"
-
-    content, err := os.ReadFile(tokenPath)
-    if err != nil {
-        log.Printf("无法读取 %s: %v", tokenPath, err)
-        // 尝试在当前目录查找
-        currentDir, _ := os.Getwd()
-        alterPath := filepath.Join(currentDir, "token.txt")
-        content, err = os.ReadFile(alterPath)
+    if keyFile := os.Getenv("TOKEN_ENCRYPTION_KEY_FILE"); TokenEncryptionKey == "" && keyFile != "" {
+        key, err := os.ReadFile(keyFile)
         if err != nil {
-            log.Fatalf("无法读取token文件 %s 和 %s: %v", tokenPath, alterPath, err)
+            log.Fatalf("无法读取密钥文件 %s: %v", keyFile, err)
         }
+        TokenEncryptionKey = strings.TrimSpace(string(key))
     }
 
-    for _, line := range strings.Split(string(content), "\n") {
-        if trimmed := strings.TrimSpace(line); trimmed != "" {
-            GSCookies = append(GSCookies, trimmed)
-        }
-    }
-        // 添加检查
-    if len(GSCookies) == 0 {
-        log.Printf("警告: token.txt 是空的")
-    } else {
-        log.Printf("成功加载 %d 个token", len(GSCookies))
+    if TokenFilePath == "" {
+        TokenFilePath = defaultTokenFilePath()
     }
-        // 检查 TOKEN_OPERATION_PASSWORD 是否设置
-    if TokenOperationPassword == "" {
-        log.Printf("警告: TOKEN_OPERATION_PASSWORD 未设置，使用默认密码: admin")
-        TokenOperationPassword = "admin"
+}
+
+// defaultTokenFilePath 返回默认的 token 文件路径(与 Dockerfile 中的路径保持一致), 不存在时使用当前目录
+func defaultTokenFilePath() string {
+    tokenPath := "/app/genspark2api/data/token.txt"
+    if _, err := os.Stat(filepath.Dir(tokenPath)); err == nil {
+        return tokenPath
     }
+    currentDir, _ := os.Getwd()
+    return filepath.Join(currentDir, "token.txt")
 }
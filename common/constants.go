@@ -16,3 +16,8 @@ var DefaultOpenaiModelList = []string{
 
 	"dall-e-3",
 }
+
+// ImageModelList 走 COPILOT_MOA_IMAGE 生图流程的模型
+var ImageModelList = []string{
+	"dall-e-3",
+}
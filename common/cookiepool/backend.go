@@ -0,0 +1,155 @@
+package cookiepool
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/common/helper"
+	logger "genspark2api/common/loggger"
+	"time"
+)
+
+// Store token 的持久化后端, 多个实例共享同一后端时即共享同一个 cookie 池
+type Store interface {
+	// Load 读取全部 token
+	Load() ([]Token, error)
+	// Save 整体替换全部 token
+	Save(tokens []Token) error
+	// Version 返回数据版本标识, 版本变化说明数据被其他实例或外部修改
+	Version() (string, error)
+	// Imported 返回已从 GS_COOKIE 等来源导入过的 cookie 摘要
+	Imported() ([]string, error)
+	// AddImported 记录已导入的 cookie 摘要, 之后不再重复导入
+	AddImported(digests []string) error
+	Close() error
+}
+
+// backend 当前使用的存储后端, 为 nil 时 token 仅保存在内存中
+var backend Store
+
+// OpenStore 按配置创建存储后端, 配置了加密密钥时对 token 加密存储
+func OpenStore() (Store, error) {
+	var store Store
+	var err error
+	switch config.TokenStore {
+	case "", "file":
+		store = NewFileStore(config.TokenFilePath)
+	case "sqlite":
+		store, err = NewSQLiteStore(config.TokenSQLitePath)
+	case "redis":
+		store, err = NewRedisStore(config.TokenRedisURL, config.TokenRedisKey)
+	default:
+		err = fmt.Errorf("unknown token store: %s", config.TokenStore)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if config.TokenEncryptionKey != "" {
+		keyring, err := NewKeyring(config.TokenEncryptionKey, config.TokenEncryptionOldKeys)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("invalid TOKEN_ENCRYPTION_KEY: %w", err)
+		}
+		store = NewEncryptedStore(store, keyring)
+	}
+	return store, nil
+}
+
+// Use 从存储后端加载 cookie 池, 之后的修改都写入该后端;
+// seed 中从未导入过的 cookie 会被追加写入, 便于通过环境变量等来源补充 cookie.
+// 每个来源 cookie 只导入一次, 之后在管理后台删除或根据 Set-Cookie 刷新都不会使其被重新导入
+func Use(store Store, seed []string) error {
+	tokens, err := store.Load()
+	if err != nil {
+		return err
+	}
+	imported, err := store.Imported()
+	if err != nil {
+		return err
+	}
+
+	encrypted, _ := store.(*EncryptedStore)
+	done := make(map[string]bool, len(imported))
+	for _, digest := range imported {
+		done[digest] = true
+	}
+	existing := make(map[string]bool, len(tokens))
+	ids := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		existing[token.Value] = true
+		ids[token.ID] = true
+	}
+	now := time.Now().Unix()
+	added := 0
+	var newDigests []string
+	for _, cookie := range seed {
+		if encrypted != nil {
+			// 来源文件本身可能已加密
+			if cookie, _, err = encrypted.keyring.Decrypt(cookie); err != nil {
+				return err
+			}
+		}
+		digest := helper.CookieID(cookie)
+		if done[digest] {
+			continue
+		}
+		done[digest] = true
+		newDigests = append(newDigests, digest)
+		// 已在存储中(ID 默认取添加时 cookie 的摘要, 刷新后保持不变)
+		if existing[cookie] || ids[digest] {
+			continue
+		}
+		existing[cookie] = true
+		tokens = append(tokens, Token{Value: cookie, CreatedAt: now})
+		added++
+	}
+
+	tokens, changed := dedupeTokens(tokens)
+	if added > 0 || changed || (encrypted != nil && encrypted.Stale()) {
+		// 追加新 cookie 并保存新分配的ID及去重结果, 同时将明文或旧密钥加密的 token 用当前密钥重新加密
+		if err := store.Save(tokens); err != nil {
+			return err
+		}
+	}
+	if len(newDigests) > 0 {
+		if err := store.AddImported(newDigests); err != nil {
+			return err
+		}
+	}
+	if len(tokens) == 0 {
+		logger.SysLog("警告: 未加载到任何token")
+	} else {
+		logger.SysLog(fmt.Sprintf("成功加载 %d 个token(新增 %d 个)", len(tokens), added))
+	}
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+	storeTokens(tokens)
+	backend = store
+	return nil
+}
+
+// Watch 定期检查存储后端的数据版本, 变化时重新加载 cookie 池
+func Watch(interval time.Duration) {
+	if backend == nil || interval <= 0 {
+		return
+	}
+	lastVersion, _ := backend.Version()
+
+	go func() {
+		for {
+			time.Sleep(interval)
+			version, err := backend.Version()
+			if err != nil || version == lastVersion {
+				continue
+			}
+			tokens, err := backend.Load()
+			if err != nil {
+				logger.SysError("重新加载token失败: " + err.Error())
+				continue
+			}
+			lastVersion = version
+			SetTokens(tokens)
+			logger.SysLog(fmt.Sprintf("token 已变更, 重新加载 %d 个token", len(tokens)))
+		}
+	}()
+}
@@ -0,0 +1,145 @@
+package cookiepool
+
+import (
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CheckStatus 主动校验 cookie 的结果
+type CheckStatus string
+
+const (
+	CheckValid       CheckStatus = "valid"
+	CheckExpired     CheckStatus = "expired"
+	CheckBanned      CheckStatus = "banned"
+	CheckRateLimited CheckStatus = "rate_limited"
+	CheckError       CheckStatus = "error"
+)
+
+// CheckResult 单个 token 的校验结果
+type CheckResult struct {
+	ID        string      `json:"id"`
+	Masked    string      `json:"masked"`
+	Status    CheckStatus `json:"status"`
+	Detail    string      `json:"detail"`
+	LatencyMs int64       `json:"latency_ms"`
+}
+
+// CheckFunc 向上游发送低成本请求校验账号的 cookie
+type CheckFunc func(account Account) (CheckStatus, string)
+
+// checkConcurrency 批量校验时的并发数, 避免短时间内请求过多
+const checkConcurrency = 4
+
+// Check 校验 token 并将结果写入健康状态
+func Check(id string, check CheckFunc) CheckResult {
+	account := AccountFor(id)
+	start := time.Now()
+	status, detail := check(account)
+	latency := time.Since(start)
+	ReportCheck(id, status, detail, latency)
+	return CheckResult{
+		ID:        id,
+		Masked:    MaskCookie(account.Cookie()),
+		Status:    status,
+		Detail:    detail,
+		LatencyMs: latency.Milliseconds(),
+	}
+}
+
+// CheckAll 并发校验多个 token, 结果顺序与输入一致
+func CheckAll(ids []string, check CheckFunc) []CheckResult {
+	results := make([]CheckResult, len(ids))
+	sem := make(chan struct{}, checkConcurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = Check(id, check)
+		}(i, id)
+	}
+	wg.Wait()
+	return results
+}
+
+// ReportCheck 根据校验结果更新健康状态: 过期或封禁直接禁用, 限流进入冷却
+func ReportCheck(id string, status CheckStatus, detail string, latency time.Duration) {
+	switch status {
+	case CheckValid:
+		ReportSuccess(id, latency)
+	case CheckRateLimited:
+		ReportFailure(id, FailureRateLimit, latency, detail)
+	case CheckExpired, CheckBanned:
+		ReportFailure(id, FailureAuth, latency, detail)
+	default:
+		ReportFailure(id, FailureOther, latency, detail)
+	}
+
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	h := getHealth(id)
+	h.CheckStatus = status
+	h.CheckDetail = detail
+	h.CheckedAt = time.Now()
+	if status == CheckExpired || status == CheckBanned {
+		h.State = StateDisabled
+	}
+}
+
+// SetDailyLimit 记录 token 是否已达到上游的每日生图额度
+func SetDailyLimit(id string, reached bool) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	getHealth(id).DailyLimit = reached
+}
+
+// StartProbe 定期重新探测已禁用的 token, 恢复后重新启用
+func StartProbe(ids func() []string, check CheckFunc) {
+	if config.CookieProbeInterval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(config.CookieProbeInterval) * time.Second)
+			for _, id := range disabledIDs(ids()) {
+				if result := Check(id, check); result.Status == CheckValid {
+					logger.SysLog("cookie " + result.Masked + " 已恢复可用")
+				}
+			}
+		}
+	}()
+}
+
+// StartValidation 定期校验所有 cookie, 在用户请求失败之前发现失效的 cookie
+func StartValidation(ids func() []string, check CheckFunc) {
+	if config.CookieCheckInterval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(config.CookieCheckInterval) * time.Second)
+			counts := make(map[CheckStatus]int)
+			for _, result := range CheckAll(ids(), check) {
+				counts[result.Status]++
+			}
+			logger.SysLog("cookie 校验完成: " + formatCounts(counts))
+		}
+	}()
+}
+
+func formatCounts(counts map[CheckStatus]int) string {
+	var result string
+	for _, status := range []CheckStatus{CheckValid, CheckExpired, CheckBanned, CheckRateLimited, CheckError} {
+		if result != "" {
+			result += ", "
+		}
+		result += string(status) + "=" + strconv.Itoa(counts[status])
+	}
+	return result
+}
@@ -0,0 +1,223 @@
+package cookiepool
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	logger "genspark2api/common/loggger"
+	"strings"
+	"sync/atomic"
+)
+
+// encryptedPrefix 加密后 token 的前缀, 格式为 enc:v1:<密钥ID>:<加密的数据密钥>:<加密的token>
+const encryptedPrefix = "enc:v1:"
+
+var (
+	ErrUnknownKey = errors.New("token encrypted with unknown key")
+	ErrInvalidKey = errors.New("encryption key must be 32 bytes encoded in base64, generate one with: openssl rand -base64 32")
+)
+
+// Keyring 信封加密使用的主密钥, primary 用于加密, 其余密钥仅用于解密(密钥轮换)
+type Keyring struct {
+	primaryID string
+	keys      map[string][]byte
+}
+
+// ParseKey 解析密钥, 密钥必须是 32 字节随机数据的 base64 编码.
+// 任意字符串经一次 SHA-256 得到的密钥容易被离线暴力破解, 不再接受
+func ParseKey(key string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(decoded) != 32 {
+		return nil, ErrInvalidKey
+	}
+	return decoded, nil
+}
+
+// legacyKey 旧版本对非 base64 密钥做 SHA-256 派生, 仅用于解密旧数据以便轮换到新密钥
+func legacyKey(key string) []byte {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return sum[:]
+}
+
+// keyID 密钥指纹, 用于解密时找到对应的密钥
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// NewKeyring 创建密钥环, oldKeys 为轮换前使用过的密钥.
+// 旧密钥可以是旧版本接受的任意字符串, 以便解密旧数据后使用新密钥重新加密
+func NewKeyring(primary string, oldKeys []string) (*Keyring, error) {
+	key, err := ParseKey(primary)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{keys: make(map[string][]byte)}
+	for _, old := range oldKeys {
+		oldKey, err := ParseKey(old)
+		if err != nil {
+			logger.SysLog("TOKEN_ENCRYPTION_OLD_KEYS 中存在非 base64 格式的旧密钥, 仅用于解密, 请执行 --rotate-token-key 重新加密")
+			oldKey = legacyKey(old)
+		}
+		k.keys[keyID(oldKey)] = oldKey
+	}
+	k.primaryID = keyID(key)
+	k.keys[k.primaryID] = key
+	return k, nil
+}
+
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, data := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, additionalData)
+}
+
+// Encrypt 使用随机数据密钥加密 token, 再用主密钥加密数据密钥
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(k.keys[k.primaryID], dataKey, []byte(k.primaryID))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + k.primaryID + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt 解密 token, 未加密的 token 原样返回; rotated 表示需要用主密钥重新加密
+func (k *Keyring) Decrypt(value string) (plaintext string, rotated bool, err error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, true, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", false, errors.New("malformed encrypted token")
+	}
+	id := parts[0]
+	key, ok := k.keys[id]
+	if !ok {
+		return "", false, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false, err
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", false, err
+	}
+	dataKey, err := open(key, wrappedKey, []byte(id))
+	if err != nil {
+		return "", false, err
+	}
+	decrypted, err := open(dataKey, ciphertext, nil)
+	if err != nil {
+		return "", false, err
+	}
+	return string(decrypted), id != k.primaryID, nil
+}
+
+// EncryptedStore 对 token 值加密后再交给底层存储, 标签等管理信息不加密
+type EncryptedStore struct {
+	Store
+	keyring *Keyring
+	// stale 上次加载时存在未加密或使用旧密钥加密的 token
+	stale atomic.Bool
+}
+
+// NewEncryptedStore 创建加密存储
+func NewEncryptedStore(store Store, keyring *Keyring) *EncryptedStore {
+	return &EncryptedStore{Store: store, keyring: keyring}
+}
+
+func (s *EncryptedStore) Load() ([]Token, error) {
+	tokens, err := s.Store.Load()
+	if err != nil {
+		return nil, err
+	}
+	stale := false
+	for i := range tokens {
+		value, rotated, err := s.keyring.Decrypt(tokens[i].Value)
+		if err != nil {
+			return nil, err
+		}
+		tokens[i].Value = value
+		stale = stale || rotated
+	}
+	s.stale.Store(stale)
+	return tokens, nil
+}
+
+func (s *EncryptedStore) Save(tokens []Token) error {
+	encrypted := make([]Token, len(tokens))
+	for i, token := range tokens {
+		value, err := s.keyring.Encrypt(token.Value)
+		if err != nil {
+			return err
+		}
+		token.Value = value
+		encrypted[i] = token
+	}
+	if err := s.Store.Save(encrypted); err != nil {
+		return err
+	}
+	s.stale.Store(false)
+	return nil
+}
+
+// Stale 上次加载的数据中是否存在需要重新加密的 token
+func (s *EncryptedStore) Stale() bool {
+	return s.stale.Load()
+}
+
+// RotateKey 使用当前主密钥重新加密存储中的全部 token, 返回 token 数量
+func RotateKey(store Store) (int, error) {
+	if _, ok := store.(*EncryptedStore); !ok {
+		return 0, errors.New("token encryption is not enabled")
+	}
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+	tokens, err := store.Load()
+	if err != nil {
+		return 0, err
+	}
+	return len(tokens), store.Save(tokens)
+}
@@ -0,0 +1,184 @@
+package cookiepool
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	testKey    = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testNewKey = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func mustKeyring(t *testing.T, primary string, oldKeys ...string) *Keyring {
+	t.Helper()
+	keyring, err := NewKeyring(primary, oldKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestParseKey(t *testing.T) {
+	if key, err := ParseKey(" " + testKey + "\n"); err != nil || len(key) != 32 {
+		t.Fatalf("ParseKey() = %d bytes, %v", len(key), err)
+	}
+	for _, key := range []string{
+		"",
+		"your-secret",
+		base64.StdEncoding.EncodeToString(make([]byte, 16)),
+		base64.StdEncoding.EncodeToString(make([]byte, 33)),
+		base64.RawStdEncoding.EncodeToString(make([]byte, 31)),
+	} {
+		if _, err := ParseKey(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("ParseKey(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+	if _, err := NewKeyring("your-secret", nil); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("NewKeyring accepted a passphrase as the primary key: %v", err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring := mustKeyring(t, testKey)
+	plaintext := "session_id=abc:def"
+
+	encrypted, err := keyring.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// enc:v1:<密钥ID>:<加密的数据密钥>:<加密的token>
+	parts := strings.Split(strings.TrimPrefix(encrypted, encryptedPrefix), ":")
+	if !strings.HasPrefix(encrypted, encryptedPrefix) || len(parts) != 3 || parts[0] != keyring.primaryID {
+		t.Fatalf("Encrypt() = %q, want enc:v1:%s:<key>:<data>", encrypted, keyring.primaryID)
+	}
+	if strings.Contains(encrypted, plaintext) {
+		t.Fatal("encrypted token contains the plaintext")
+	}
+	if again, _ := keyring.Encrypt(plaintext); again == encrypted {
+		t.Fatal("Encrypt is deterministic")
+	}
+
+	decrypted, rotated, err := keyring.Decrypt(encrypted)
+	if err != nil || decrypted != plaintext || rotated {
+		t.Fatalf("Decrypt() = %q, %v, %v", decrypted, rotated, err)
+	}
+	// 未加密的 token 原样返回并需要重新加密
+	decrypted, rotated, err = keyring.Decrypt(plaintext)
+	if err != nil || decrypted != plaintext || !rotated {
+		t.Fatalf("Decrypt(plaintext) = %q, %v, %v", decrypted, rotated, err)
+	}
+
+	tampered := []byte(encrypted)
+	tampered[len(tampered)-2] ^= 1
+	for name, value := range map[string]string{
+		"tampered":    string(tampered),
+		"missing":     encryptedPrefix + parts[0] + ":" + parts[1],
+		"swapped key": encryptedPrefix + parts[0] + ":" + parts[2] + ":" + parts[1],
+		"bad base64":  encryptedPrefix + parts[0] + ":!!:" + parts[2],
+	} {
+		if _, _, err := keyring.Decrypt(value); err == nil {
+			t.Errorf("%s: Decrypt accepted %q", name, value)
+		}
+	}
+	if _, _, err := mustKeyring(t, testNewKey).Decrypt(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Decrypt with another key = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	encrypted, err := mustKeyring(t, testKey).Encrypt("session_id=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := mustKeyring(t, testNewKey, testKey)
+	decrypted, stale, err := rotated.Decrypt(encrypted)
+	if err != nil || decrypted != "session_id=a" || !stale {
+		t.Fatalf("Decrypt with old key = %q, %v, %v, want stale", decrypted, stale, err)
+	}
+
+	// 旧版本使用 SHA-256 派生的密钥只能作为旧密钥解密
+	legacy := &Keyring{primaryID: keyID(legacyKey("old-secret")), keys: map[string][]byte{keyID(legacyKey("old-secret")): legacyKey("old-secret")}}
+	encrypted, err = legacy.Encrypt("session_id=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, stale, err = mustKeyring(t, testKey, "old-secret").Decrypt(encrypted)
+	if err != nil || decrypted != "session_id=b" || !stale {
+		t.Fatalf("Decrypt with legacy key = %q, %v, %v, want stale", decrypted, stale, err)
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	tokens := []Token{
+		{ID: "id-a", Value: "session_id=a", Label: "主账号", CreatedAt: 1700000000},
+		{ID: "id-b", Value: "session_id=b", Disabled: true},
+	}
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			inner := open(t)
+			// 已有的明文 token 加载后标记为需要重新加密
+			if err := inner.Save(tokens[:1]); err != nil {
+				t.Fatal(err)
+			}
+			store := NewEncryptedStore(inner, mustKeyring(t, testKey))
+			loaded, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, tokens[:1]) || !store.Stale() {
+				t.Fatalf("Load() = %+v, stale %v", loaded, store.Stale())
+			}
+
+			if err := store.Save(tokens); err != nil {
+				t.Fatal(err)
+			}
+			if store.Stale() {
+				t.Fatal("store still stale after Save")
+			}
+			raw, err := inner.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, token := range raw {
+				if !strings.HasPrefix(token.Value, encryptedPrefix) {
+					t.Fatalf("backend stored %q in plaintext", token.Value)
+				}
+				// 管理信息不加密
+				token.Value = tokens[i].Value
+				if !reflect.DeepEqual(token, tokens[i]) {
+					t.Fatalf("backend stored %+v, want %+v", token, tokens[i])
+				}
+			}
+			loaded, err = store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, tokens) || store.Stale() {
+				t.Fatalf("Load() = %+v, stale %v", loaded, store.Stale())
+			}
+
+			// 轮换密钥后使用新密钥重新加密全部 token
+			rotated := NewEncryptedStore(inner, mustKeyring(t, testNewKey, testKey))
+			if _, err := rotated.Load(); err != nil || !rotated.Stale() {
+				t.Fatalf("Load() with rotated key: stale %v, %v", rotated.Stale(), err)
+			}
+			count, err := RotateKey(rotated)
+			if err != nil || count != len(tokens) {
+				t.Fatalf("RotateKey() = %d, %v", count, err)
+			}
+			if _, err := NewEncryptedStore(inner, mustKeyring(t, testNewKey)).Load(); err != nil {
+				t.Fatalf("Load() without the old key after rotation: %v", err)
+			}
+			if _, err := store.Load(); !errors.Is(err, ErrUnknownKey) {
+				t.Fatalf("Load() with only the old key = %v, want ErrUnknownKey", err)
+			}
+			if _, err := RotateKey(inner); err == nil {
+				t.Fatal("RotateKey accepted an unencrypted store")
+			}
+		})
+	}
+}
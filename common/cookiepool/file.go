@@ -0,0 +1,166 @@
+package cookiepool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"genspark2api/common/helper"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tokenMeta token 文件之外单独保存的管理信息, 以 cookie 的摘要为 key
+type tokenMeta struct {
+	ID        string `json:"id,omitempty"`
+	Label     string `json:"label,omitempty"`
+	Disabled  bool   `json:"disabled,omitempty"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+	Proxy     string `json:"proxy,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+}
+
+// metaPath 返回与 token 文件同目录的管理信息文件路径
+func metaPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "token_meta.json")
+}
+
+// importedPath 返回与 token 文件同目录的已导入 cookie 记录文件路径
+func importedPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "token_imported.json")
+}
+
+// ParseTokens 按行解析 token 文件内容, 忽略空行
+func ParseTokens(content string) []string {
+	var cookies []string
+	for _, line := range strings.Split(content, "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			cookies = append(cookies, trimmed)
+		}
+	}
+	return cookies
+}
+
+// FileStore 基于 token 文件的存储, 每行一个 token, 管理信息保存在同目录的 token_meta.json
+type FileStore struct {
+	path string
+}
+
+// NewFileStore 创建基于 token 文件的存储
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load 读取 token 文件及管理信息, 文件不存在时视为空
+func (s *FileStore) Load() ([]Token, error) {
+	content, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	metas := make(map[string]tokenMeta)
+	if metaContent, err := os.ReadFile(metaPath(s.path)); err == nil {
+		_ = json.Unmarshal(metaContent, &metas)
+	}
+
+	var tokens []Token
+	for _, cookie := range ParseTokens(string(content)) {
+		token := Token{Value: cookie}
+		if meta, ok := metas[helper.CookieID(cookie)]; ok {
+			token.ID = meta.ID
+			token.Label, token.Disabled, token.CreatedAt = meta.Label, meta.Disabled, meta.CreatedAt
+			token.Proxy, token.Profile = meta.Proxy, meta.Profile
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// Save 写入 token 文件及管理信息
+func (s *FileStore) Save(tokens []Token) error {
+	var builder strings.Builder
+	metas := make(map[string]tokenMeta)
+	for _, token := range tokens {
+		builder.WriteString(token.Value + "\n")
+		metas[helper.CookieID(token.Value)] = tokenMeta{
+			ID:        token.ID,
+			Label:     token.Label,
+			Disabled:  token.Disabled,
+			CreatedAt: token.CreatedAt,
+			Proxy:     token.Proxy,
+			Profile:   token.Profile,
+		}
+	}
+	metaContent, err := json.MarshalIndent(metas, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(metaPath(s.path), metaContent, 0600); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, []byte(builder.String()), 0600)
+}
+
+// Version 以 token 文件及管理信息文件内容的摘要作为版本.
+// 修改时间的精度可能不足以区分同一时刻的两次写入, 大小相同的修改也不会改变大小
+func (s *FileStore) Version() (string, error) {
+	content, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	metaContent, err := os.ReadFile(metaPath(s.path))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write(content)
+	hash.Write([]byte{0})
+	hash.Write(metaContent)
+	return hex.EncodeToString(hash.Sum(nil)[:16]), nil
+}
+
+// Imported 读取已导入 cookie 的记录, 文件不存在时视为空
+func (s *FileStore) Imported() ([]string, error) {
+	content, err := os.ReadFile(importedPath(s.path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var digests []string
+	if err := json.Unmarshal(content, &digests); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+func (s *FileStore) AddImported(digests []string) error {
+	existing, err := s.Imported()
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(append(existing, digests...), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(importedPath(s.path), content, 0600)
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// writeFileAtomic 先写临时文件再替换, 避免写入中断导致文件损坏
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,212 @@
+package cookiepool
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State cookie 的可用状态
+type State string
+
+const (
+	StateActive   State = "active"
+	StateCooldown State = "cooldown"
+	StateDisabled State = "disabled"
+)
+
+// FailureKind 上游请求失败的类型
+type FailureKind string
+
+const (
+	FailureAuth      FailureKind = "auth"
+	FailureRateLimit FailureKind = "rate_limit"
+	FailureOther     FailureKind = "other"
+	// FailureProxy 代理或网络连接失败, 与 cookie 本身无关
+	FailureProxy FailureKind = "proxy"
+)
+
+// Health 单个 cookie 的健康状态, 按 token ID 记录, cookie 刷新后保持不变
+type Health struct {
+	ID                  string      `json:"id"`
+	Masked              string      `json:"masked"`
+	State               State       `json:"state"`
+	ConsecutiveFailures int         `json:"consecutive_failures"`
+	AuthFailures        int         `json:"auth_failures"`
+	RateLimits          int64       `json:"rate_limits"`
+	Successes           int64       `json:"successes"`
+	Failures            int64       `json:"failures"`
+	ProxyFailures       int64       `json:"proxy_failures"`
+	LastLatencyMs       int64       `json:"last_latency_ms"`
+	AvgLatencyMs        int64       `json:"avg_latency_ms"`
+	LastError           string      `json:"last_error"`
+	LastUsedAt          time.Time   `json:"last_used_at"`
+	CooldownUntil       time.Time   `json:"cooldown_until"`
+	InFlight            int64       `json:"in_flight"`
+	Weight              int         `json:"weight"`
+	CheckStatus         CheckStatus `json:"check_status"`
+	CheckDetail         string      `json:"check_detail"`
+	CheckedAt           time.Time   `json:"checked_at"`
+	DailyLimit          bool        `json:"daily_limit"`
+}
+
+var (
+	healthMutex sync.Mutex
+	// healths 按 token ID 保存的健康记录
+	healths = make(map[string]*Health)
+)
+
+// MaskCookie 返回脱敏后的 cookie, 仅保留首尾少量字符
+func MaskCookie(cookie string) string {
+	if len(cookie) <= 12 {
+		return "****"
+	}
+	return fmt.Sprintf("%s****%s", cookie[:6], cookie[len(cookie)-4:])
+}
+
+// getHealth 获取或创建健康记录, 调用方需持有锁
+func getHealth(id string) *Health {
+	h, ok := healths[id]
+	if !ok {
+		h = &Health{ID: id, State: StateActive}
+		healths[id] = h
+	}
+	return h
+}
+
+// pruneHealths 删除已不在 token 列表中的健康记录, 避免删除或替换 token 后记录无限增长
+func pruneHealths(ids map[string]int) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	for id := range healths {
+		if _, ok := ids[id]; !ok {
+			delete(healths, id)
+		}
+	}
+}
+
+// snapshotLocked 返回健康记录的副本, 脱敏的 cookie 取 token 当前的值, 调用方需持有锁
+func snapshotLocked(id string) Health {
+	snapshot := *getHealth(id)
+	snapshot.Masked = MaskCookie(AccountFor(id).Cookie())
+	return snapshot
+}
+
+// refreshState 冷却时间结束后恢复为可用, 调用方需持有锁
+func (h *Health) refreshState(now time.Time) {
+	if h.State == StateCooldown && now.After(h.CooldownUntil) {
+		h.State = StateActive
+	}
+}
+
+func (h *Health) recordLatency(latency time.Duration) {
+	ms := latency.Milliseconds()
+	h.LastLatencyMs = ms
+	if h.AvgLatencyMs == 0 {
+		h.AvgLatencyMs = ms
+	} else {
+		// 指数移动平均, 近期请求权重更高
+		h.AvgLatencyMs = (h.AvgLatencyMs*4 + ms) / 5
+	}
+}
+
+// IsAvailable 判断 token 当前是否可被选中
+func IsAvailable(id string) bool {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	h := getHealth(id)
+	h.refreshState(time.Now())
+	return h.State == StateActive
+}
+
+// ReportSuccess 记录一次成功的上游请求
+func ReportSuccess(id string, latency time.Duration) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	h := getHealth(id)
+	h.Successes++
+	h.ConsecutiveFailures = 0
+	h.AuthFailures = 0
+	h.LastUsedAt = time.Now()
+	h.recordLatency(latency)
+	if h.State == StateDisabled {
+		h.State = StateActive
+	}
+}
+
+// ReportFailure 记录一次失败的上游请求, 并按失败类型进入冷却或禁用
+func ReportFailure(id string, kind FailureKind, latency time.Duration, reason string) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	now := time.Now()
+	h := getHealth(id)
+	h.LastError = reason
+	if kind == FailureProxy {
+		// 代理故障由代理健康状态处理, 不影响 cookie 的冷却和禁用
+		h.ProxyFailures++
+		return
+	}
+	h.Failures++
+	h.ConsecutiveFailures++
+	h.LastUsedAt = now
+	if latency > 0 {
+		h.recordLatency(latency)
+	}
+
+	switch kind {
+	case FailureAuth:
+		h.AuthFailures++
+		if h.AuthFailures >= config.CookieMaxAuthFailures {
+			h.State = StateDisabled
+		}
+	case FailureRateLimit:
+		h.RateLimits++
+		h.cooldown(now)
+	default:
+		if h.ConsecutiveFailures >= config.CookieMaxFailures {
+			h.cooldown(now)
+		}
+	}
+}
+
+func (h *Health) cooldown(now time.Time) {
+	if h.State == StateDisabled {
+		return
+	}
+	h.State = StateCooldown
+	h.CooldownUntil = now.Add(time.Duration(config.CookieCooldownSeconds) * time.Second)
+}
+
+// Snapshot 返回指定 token 的健康状态
+func Snapshot(ids []string) []Health {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	now := time.Now()
+	result := make([]Health, 0, len(ids))
+	for _, id := range ids {
+		getHealth(id).refreshState(now)
+		snapshot := snapshotLocked(id)
+		snapshot.InFlight = InFlight(id)
+		snapshot.Weight = configWeight(id)
+		result = append(result, snapshot)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].State < result[j].State
+	})
+	return result
+}
+
+// disabledIDs 返回已禁用的 token
+func disabledIDs(ids []string) []string {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	var result []string
+	for _, id := range ids {
+		if getHealth(id).State == StateDisabled {
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,39 @@
+package cookiepool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthsPrunedWithTokens(t *testing.T) {
+	SetCookies([]string{"session_id=a", "session_id=b", "session_id=c"})
+	defer SetCookies(nil)
+	ids := IDs()
+	for _, id := range ids {
+		ReportSuccess(id, time.Millisecond)
+	}
+
+	tracked := func() map[string]bool {
+		healthMutex.Lock()
+		defer healthMutex.Unlock()
+		result := make(map[string]bool, len(healths))
+		for id := range healths {
+			result[id] = true
+		}
+		return result
+	}
+
+	// 通过管理接口删除的 token 不再保留健康记录
+	if err := DeleteToken(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := tracked(); got[ids[0]] || !got[ids[1]] || !got[ids[2]] {
+		t.Fatalf("healths after DeleteToken = %v", got)
+	}
+
+	// 整体替换 token 列表时同样清理
+	SetCookies([]string{"session_id=c"})
+	if got := tracked(); len(got) != 1 || !got[ids[2]] {
+		t.Fatalf("healths after SetCookies = %v, want only %s", got, ids[2])
+	}
+}
@@ -0,0 +1,85 @@
+package cookiepool
+
+import (
+	"errors"
+	"genspark2api/common/config"
+	"genspark2api/common/fingerprint"
+	"sync"
+)
+
+var ErrNoAvailableCookie = errors.New("no available cookie")
+
+var (
+	selectorOnce sync.Once
+	selector     Selector
+
+	inFlightMutex sync.Mutex
+	// inFlight 各 token 进行中的请求数, 按 token ID 计数
+	inFlight = make(map[string]int64)
+)
+
+func getSelector() Selector {
+	selectorOnce.Do(func() {
+		selector = NewSelector(config.CookieSelectStrategy)
+	})
+	return selector
+}
+
+// Account 请求占用的账号, ID 在 cookie 刷新后保持不变
+type Account struct {
+	ID string
+	// token 选中时的 token, 被删除后继续使用其 cookie、代理及指纹
+	token Token
+}
+
+// AccountFor 返回ID对应的账号
+func AccountFor(id string) Account {
+	token, _ := TokenByID(id)
+	return Account{ID: id, token: token}
+}
+
+// current 返回账号当前的 token, 已被删除时返回选中时的 token
+func (a Account) current() Token {
+	if token, ok := TokenByID(a.ID); ok {
+		return token
+	}
+	return a.token
+}
+
+// Cookie 返回账号当前的 cookie, 根据 Set-Cookie 刷新后返回刷新后的值
+func (a Account) Cookie() string {
+	return a.current().Value
+}
+
+// Proxy 返回账号使用的上游代理, 同一账号的所有请求使用相同的出口
+func (a Account) Proxy() string {
+	return tokenProxy(a.current())
+}
+
+// Profile 返回账号使用的浏览器指纹配置
+func (a Account) Profile() fingerprint.Profile {
+	return tokenProfile(a.current())
+}
+
+// releaser 返回释放 token 的函数(多次调用只生效一次), 释放后为排队的请求分配 token
+func releaser(id string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			inFlightMutex.Lock()
+			inFlight[id]--
+			if inFlight[id] <= 0 {
+				delete(inFlight, id)
+			}
+			inFlightMutex.Unlock()
+			dispatch()
+		})
+	}
+}
+
+// InFlight 返回 token 进行中的请求数
+func InFlight(id string) int64 {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+	return inFlightLocked(id)
+}
@@ -0,0 +1,26 @@
+package cookiepool
+
+import (
+	"genspark2api/common/config"
+	"genspark2api/common/fingerprint"
+)
+
+// ProfileFor 返回 token 使用的浏览器指纹配置: 优先使用绑定的配置, 其次为全局配置
+func ProfileFor(id string) fingerprint.Profile {
+	token, _ := TokenByID(id)
+	return tokenProfile(token)
+}
+
+// tokenProfile 返回 token 绑定的浏览器指纹配置, 未绑定时返回全局配置
+func tokenProfile(token Token) fingerprint.Profile {
+	name := config.BrowserProfile
+	if token.Profile != "" {
+		name = token.Profile
+	}
+	if profile, ok := fingerprint.Get(name); ok {
+		return profile
+	}
+	// 绑定的配置已从配置文件中移除时回退到全局配置
+	profile, _ := fingerprint.Get(config.BrowserProfile)
+	return profile
+}
@@ -0,0 +1,42 @@
+package cookiepool
+
+import (
+	"genspark2api/common/config"
+	"testing"
+)
+
+func TestTokenProxy(t *testing.T) {
+	previous := config.ProxyURL
+	config.ProxyURL = "http://127.0.0.1:8080"
+	defer func() { config.ProxyURL = previous }()
+
+	tests := []struct {
+		name      string
+		proxy     string
+		want      string
+		available bool
+	}{
+		{"unbound", "", "http://127.0.0.1:8080", true},
+		{"bound", "socks5://127.0.0.1:1080", "socks5://127.0.0.1:1080", true},
+		// 无效的绑定代理不回退到全局代理, 该 cookie 不可用
+		{"unsupported scheme", "ftp://127.0.0.1:21", "ftp://127.0.0.1:21", false},
+		{"missing port", "socks5://127.0.0.1", "socks5://127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetTokens([]Token{{Value: "session_id=" + tt.name, Proxy: tt.proxy}})
+			defer SetTokens(nil)
+			id := IDs()[0]
+
+			if got := ProxyFor(id); got != tt.want {
+				t.Fatalf("ProxyFor() = %q, want %q", got, tt.want)
+			}
+			if got := AccountFor(id).Proxy(); got != tt.want {
+				t.Fatalf("Account.Proxy() = %q, want %q", got, tt.want)
+			}
+			if got := len(availableIDs(IDs())) == 1; got != tt.available {
+				t.Fatalf("available = %v, want %v", got, tt.available)
+			}
+		})
+	}
+}
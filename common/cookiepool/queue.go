@@ -0,0 +1,245 @@
+package cookiepool
+
+import (
+	"context"
+	"errors"
+	"genspark2api/common/config"
+	"sort"
+	"time"
+)
+
+var (
+	ErrQueueFull    = errors.New("all cookies are busy and the request queue is full")
+	ErrQueueTimeout = errors.New("timed out waiting for an available cookie")
+)
+
+// waiter 排队等待 cookie 的请求
+type waiter struct {
+	// candidates 返回可使用的 token ID
+	candidates func() []string
+	key        string
+	priority   int
+	seq        uint64
+	enqueuedAt time.Time
+	// result 分配到的 token ID, 缓冲为1, 分配时不阻塞
+	result chan string
+}
+
+// QueueStats 请求队列的统计信息
+type QueueStats struct {
+	Depth      int   `json:"depth"`
+	MaxDepth   int   `json:"max_depth"`
+	Capacity   int   `json:"capacity"`
+	InFlight   int64 `json:"in_flight"`
+	Queued     int64 `json:"queued"`
+	Dequeued   int64 `json:"dequeued"`
+	Timeouts   int64 `json:"timeouts"`
+	Rejected   int64 `json:"rejected"`
+	LastWaitMs int64 `json:"last_wait_ms"`
+	AvgWaitMs  int64 `json:"avg_wait_ms"`
+	MaxWaitMs  int64 `json:"max_wait_ms"`
+}
+
+var (
+	// 以下变量均由 inFlightMutex 保护
+	waiters    []*waiter
+	waiterSeq  uint64
+	queueStats QueueStats
+)
+
+// inFlightLocked 返回 token 进行中的请求数, 调用方需持有 inFlightMutex
+func inFlightLocked(id string) int64 {
+	return inFlight[id]
+}
+
+// underLimit 判断 token 进行中的请求数是否未达到上限, 调用方需持有 inFlightMutex
+func underLimit(id string) bool {
+	return config.CookieMaxInFlight <= 0 || inFlightLocked(id) < int64(config.CookieMaxInFlight)
+}
+
+// tryAcquireLocked 从可用且未达到并发上限的 token 中选择一个并占用, 调用方需持有 inFlightMutex
+func tryAcquireLocked(available []string, key string) (string, bool) {
+	free := make([]string, 0, len(available))
+	for _, id := range available {
+		if underLimit(id) {
+			free = append(free, id)
+		}
+	}
+	if len(free) == 0 {
+		return "", false
+	}
+	id := getSelector().Select(free, key)
+	inFlight[id]++
+	return id, true
+}
+
+// availableIDs 过滤出未冷却、未禁用且代理可用的 token
+func availableIDs(ids []string) []string {
+	available := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if IsAvailable(id) && ProxyAvailable(ProxyFor(id)) {
+			available = append(available, id)
+		}
+	}
+	return available
+}
+
+// AcquireCookie 按配置的策略选择并占用一个 cookie, 跳过冷却中、已禁用及达到并发上限的 cookie.
+// candidates 返回可使用的 token ID, key 用于粘性选择, 通常为 API key 或请求中的 user 字段.
+// 所有 cookie 都达到并发上限时按优先级排队等待, 队列已满返回 ErrQueueFull, 等待超时返回 ErrQueueTimeout.
+// 返回的函数用于在请求结束时释放 cookie
+func AcquireCookie(ctx context.Context, candidates func() []string, key string, priority int) (Account, func(), error) {
+	available := availableIDs(candidates())
+	if len(available) == 0 {
+		return Account{}, nil, ErrNoAvailableCookie
+	}
+
+	inFlightMutex.Lock()
+	// 已有请求排队时, 只有优先级高于队首的请求可以直接占用空闲的 cookie,
+	// 否则冷却结束后空出的 cookie 会先被新请求占用, 越过排队中的高优先级请求
+	if len(waiters) == 0 || priority > waiters[0].priority {
+		if id, ok := tryAcquireLocked(available, key); ok {
+			inFlightMutex.Unlock()
+			return AccountFor(id), releaser(id), nil
+		}
+	}
+	if len(waiters) >= config.QueueMaxSize {
+		queueStats.Rejected++
+		inFlightMutex.Unlock()
+		return Account{}, nil, ErrQueueFull
+	}
+	waiterSeq++
+	w := &waiter{
+		candidates: candidates,
+		key:        key,
+		priority:   priority,
+		seq:        waiterSeq,
+		enqueuedAt: time.Now(),
+		result:     make(chan string, 1),
+	}
+	enqueueLocked(w)
+	queued := len(waiters) > 1
+	inFlightMutex.Unlock()
+	if queued {
+		// 按优先级分配可能已空出的 cookie, 不等待下一次定期分配
+		dispatch()
+	}
+
+	timeout := time.NewTimer(time.Duration(config.QueueTimeout) * time.Second)
+	defer timeout.Stop()
+	// 冷却结束的 cookie 不会触发释放, 定期重新分配
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case id := <-w.result:
+			return AccountFor(id), releaser(id), nil
+		case <-ticker.C:
+			dispatch()
+		case <-timeout.C:
+			return abandon(w, ErrQueueTimeout)
+		case <-ctx.Done():
+			return abandon(w, ctx.Err())
+		}
+	}
+}
+
+// enqueueLocked 按优先级(高者优先)和入队顺序插入等待队列, 调用方需持有 inFlightMutex
+func enqueueLocked(w *waiter) {
+	index := sort.Search(len(waiters), func(i int) bool {
+		return waiters[i].priority < w.priority
+	})
+	waiters = append(waiters, nil)
+	copy(waiters[index+1:], waiters[index:])
+	waiters[index] = w
+
+	queueStats.Queued++
+	if len(waiters) > queueStats.MaxDepth {
+		queueStats.MaxDepth = len(waiters)
+	}
+}
+
+// abandon 将超时或取消的请求移出队列; 若移出前已分配到 cookie 则继续使用
+func abandon(w *waiter, err error) (Account, func(), error) {
+	inFlightMutex.Lock()
+	for i, other := range waiters {
+		if other == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			if errors.Is(err, ErrQueueTimeout) {
+				queueStats.Timeouts++
+			}
+			inFlightMutex.Unlock()
+			return Account{}, nil, err
+		}
+	}
+	inFlightMutex.Unlock()
+	id := <-w.result
+	return AccountFor(id), releaser(id), nil
+}
+
+// dispatch 按优先级为排队的请求分配空闲的 cookie
+func dispatch() {
+	inFlightMutex.Lock()
+	pending := append([]*waiter(nil), waiters...)
+	inFlightMutex.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	// 健康状态检查需要 healthMutex, 在持有 inFlightMutex 之前完成
+	available := make(map[*waiter][]string, len(pending))
+	for _, w := range pending {
+		available[w] = availableIDs(w.candidates())
+	}
+
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+	remaining := waiters[:0]
+	for _, w := range waiters {
+		ids, checked := available[w]
+		if !checked {
+			remaining = append(remaining, w)
+			continue
+		}
+		id, ok := tryAcquireLocked(ids, w.key)
+		if !ok {
+			remaining = append(remaining, w)
+			continue
+		}
+		recordWaitLocked(time.Since(w.enqueuedAt))
+		w.result <- id
+	}
+	for i := len(remaining); i < len(waiters); i++ {
+		waiters[i] = nil
+	}
+	waiters = remaining
+}
+
+// recordWaitLocked 记录排队等待时间, 调用方需持有 inFlightMutex
+func recordWaitLocked(wait time.Duration) {
+	ms := wait.Milliseconds()
+	queueStats.Dequeued++
+	queueStats.LastWaitMs = ms
+	if ms > queueStats.MaxWaitMs {
+		queueStats.MaxWaitMs = ms
+	}
+	if queueStats.AvgWaitMs == 0 {
+		queueStats.AvgWaitMs = ms
+	} else {
+		queueStats.AvgWaitMs = (queueStats.AvgWaitMs*4 + ms) / 5
+	}
+}
+
+// GetQueueStats 返回请求队列的统计信息
+func GetQueueStats() QueueStats {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+	stats := queueStats
+	stats.Depth = len(waiters)
+	stats.Capacity = config.QueueMaxSize
+	for _, count := range inFlight {
+		stats.InFlight += count
+	}
+	return stats
+}
@@ -0,0 +1,143 @@
+package cookiepool
+
+import (
+	"context"
+	"genspark2api/common/config"
+	"testing"
+	"time"
+)
+
+type acquired struct {
+	account Account
+	release func()
+	err     error
+}
+
+// acquireAsync 在后台调用 AcquireCookie, 结果写入返回的 channel
+func acquireAsync(priority int) <-chan acquired {
+	done := make(chan acquired, 1)
+	go func() {
+		account, release, err := AcquireCookie(context.Background(), IDs, "", priority)
+		done <- acquired{account, release, err}
+	}()
+	return done
+}
+
+// waitQueueDepth 等待队列达到指定深度
+func waitQueueDepth(t *testing.T, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for GetQueueStats().Depth != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth %d, want %d", GetQueueStats().Depth, depth)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAcquireCookieKeepsPriorityWhenCooldownEnds(t *testing.T) {
+	previous := []int{config.CookieMaxInFlight, config.QueueMaxSize, config.QueueTimeout}
+	config.CookieMaxInFlight, config.QueueMaxSize, config.QueueTimeout = 1, 10, 10
+	SetCookies([]string{"session_id=busy", "session_id=cooling"})
+	defer func() {
+		config.CookieMaxInFlight, config.QueueMaxSize, config.QueueTimeout = previous[0], previous[1], previous[2]
+		SetCookies(nil)
+	}()
+	busy, cooling := IDs()[0], IDs()[1]
+
+	ReportFailure(cooling, FailureRateLimit, 0, "status 429")
+	if IsAvailable(cooling) {
+		t.Fatal("cookie not cooling down after a rate limit")
+	}
+	account, releaseBusy, err := AcquireCookie(context.Background(), IDs, "", 0)
+	if err != nil || account.ID != busy {
+		t.Fatalf("AcquireCookie() = %s, %v, want %s", account.ID, err, busy)
+	}
+	defer releaseBusy()
+
+	// 所有可用的 cookie 都达到并发上限, 高优先级请求排队
+	high := acquireAsync(10)
+	waitQueueDepth(t, 1)
+
+	// 冷却结束只会在定期分配时被发现, 在此之前到达的低优先级请求不能越过队列
+	healthMutex.Lock()
+	healths[cooling].CooldownUntil = time.Now().Add(-time.Second)
+	healthMutex.Unlock()
+	low := acquireAsync(0)
+
+	select {
+	case got := <-high:
+		if got.err != nil || got.account.ID != cooling {
+			t.Fatalf("high priority request got %s, %v, want %s", got.account.ID, got.err, cooling)
+		}
+		defer got.release()
+	case got := <-low:
+		if got.release != nil {
+			got.release()
+		}
+		t.Fatalf("low priority request got %s ahead of the queued high priority request", got.account.ID)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("high priority request not served after the cooldown ended")
+	}
+
+	// 低优先级请求继续排队, 直到有 cookie 被释放
+	waitQueueDepth(t, 1)
+	releaseBusy()
+	select {
+	case got := <-low:
+		if got.err != nil || got.account.ID != busy {
+			t.Fatalf("low priority request got %s, %v, want %s", got.account.ID, got.err, busy)
+		}
+		got.release()
+	case <-time.After(2 * time.Second):
+		t.Fatal("low priority request not served after a release")
+	}
+}
+
+func TestAcquireCookieHigherPriorityBypassesQueue(t *testing.T) {
+	previous := []int{config.CookieMaxInFlight, config.QueueMaxSize, config.QueueTimeout}
+	config.CookieMaxInFlight, config.QueueMaxSize, config.QueueTimeout = 1, 10, 10
+	SetCookies([]string{"session_id=first", "session_id=second"})
+	defer func() {
+		config.CookieMaxInFlight, config.QueueMaxSize, config.QueueTimeout = previous[0], previous[1], previous[2]
+		SetCookies(nil)
+	}()
+	first := IDs()[0]
+
+	// 只允许使用 first, 占满后低优先级请求排队
+	onlyFirst := func() []string { return []string{first} }
+	_, releaseFirst, err := AcquireCookie(context.Background(), onlyFirst, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseFirst()
+	queued := make(chan error, 1)
+	go func() {
+		_, release, err := AcquireCookie(context.Background(), onlyFirst, "", 0)
+		if release != nil {
+			release()
+		}
+		queued <- err
+	}()
+	waitQueueDepth(t, 1)
+
+	// 优先级更高的请求直接使用空闲的 cookie
+	account, release, err := AcquireCookie(context.Background(), IDs, "", 5)
+	if err != nil || account.ID != IDs()[1] {
+		t.Fatalf("AcquireCookie() = %s, %v, want the idle cookie", account.ID, err)
+	}
+	release()
+
+	// 优先级相同的请求排在后面, 但不影响可以使用其他 cookie 的请求
+	account, release, err = AcquireCookie(context.Background(), IDs, "", 0)
+	if err != nil || account.ID != IDs()[1] {
+		t.Fatalf("AcquireCookie() = %s, %v, want the idle cookie", account.ID, err)
+	}
+	release()
+	waitQueueDepth(t, 1)
+
+	releaseFirst()
+	if err := <-queued; err != nil {
+		t.Fatal(err)
+	}
+}
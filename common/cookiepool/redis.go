@@ -0,0 +1,107 @@
+package cookiepool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout 单次 Redis 操作的超时时间
+const redisTimeout = 5 * time.Second
+
+// RedisStore 基于 Redis 的存储, token 列表以 JSON 保存在 key 中, 版本号保存在 key:version 中,
+// 已导入 cookie 的记录保存在集合 key:imported 中
+type RedisStore struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisStore 连接 Redis, url 格式如 redis://:password@host:6379/0
+func NewRedisStore(url string, key string) (*RedisStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisStore{client: client, key: key}, nil
+}
+
+func (s *RedisStore) Load() ([]Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	content, err := s.client.Get(ctx, s.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Save 在事务中整体替换 token 并递增版本
+func (s *RedisStore) Save(tokens []Token) error {
+	content, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.key, content, 0)
+		pipe.Incr(ctx, s.key+":version")
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) Version() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	version, err := s.client.Get(ctx, s.key+":version").Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return version, err
+}
+
+func (s *RedisStore) Imported() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.SMembers(ctx, s.key+":imported").Result()
+}
+
+func (s *RedisStore) AddImported(digests []string) error {
+	if len(digests) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	members := make([]interface{}, len(digests))
+	for i, digest := range digests {
+		members[i] = digest
+	}
+	return s.client.SAdd(ctx, s.key+":imported", members...).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,104 @@
+package cookiepool
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cookiePair cookie 请求头中的一项
+type cookiePair struct {
+	name  string
+	value string
+}
+
+func parseCookiePairs(cookie string) []cookiePair {
+	var pairs []cookiePair
+	for _, part := range strings.Split(cookie, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			continue
+		}
+		pairs = append(pairs, cookiePair{name: strings.TrimSpace(name), value: strings.TrimSpace(value)})
+	}
+	return pairs
+}
+
+// MergeSetCookie 将响应的 Set-Cookie 合并进 cookie 请求头: 更新或追加同名项, 删除已过期的项;
+// 返回合并后的 cookie 及是否有变化
+func MergeSetCookie(cookie string, setCookies []*http.Cookie) (string, bool) {
+	pairs := parseCookiePairs(cookie)
+	now := time.Now()
+	changed := false
+	for _, setCookie := range setCookies {
+		if setCookie == nil || setCookie.Name == "" {
+			continue
+		}
+		expired := setCookie.MaxAge < 0 || (!setCookie.Expires.IsZero() && setCookie.Expires.Before(now))
+		index := -1
+		for i, pair := range pairs {
+			if pair.name == setCookie.Name {
+				index = i
+				break
+			}
+		}
+		switch {
+		case expired && index >= 0:
+			pairs = append(pairs[:index], pairs[index+1:]...)
+			changed = true
+		case expired:
+		case index >= 0 && pairs[index].value != setCookie.Value:
+			pairs[index].value = setCookie.Value
+			changed = true
+		case index < 0:
+			pairs = append(pairs, cookiePair{name: setCookie.Name, value: setCookie.Value})
+			changed = true
+		}
+	}
+	if !changed {
+		return cookie, false
+	}
+	parts := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		parts = append(parts, pair.name+"="+pair.value)
+	}
+	return strings.Join(parts, "; "), true
+}
+
+// errUnchanged 合并 Set-Cookie 后 cookie 没有变化
+var errUnchanged = errors.New("cookie unchanged")
+
+// RefreshCookie 将 Set-Cookie 合并进 token 当前的 cookie 并持久化, 返回是否有变化;
+// token 的ID、标签等管理信息及健康状态保持不变
+func RefreshCookie(id string, setCookies []*http.Cookie) (bool, error) {
+	token, ok := TokenByID(id)
+	if !ok {
+		return false, ErrTokenNotFound
+	}
+	if _, changed := MergeSetCookie(token.Value, setCookies); !changed {
+		return false, nil
+	}
+	err := update(func(tokens []Token) ([]Token, error) {
+		for i := range tokens {
+			if tokens[i].ID != id {
+				continue
+			}
+			// 以写锁内的最新值为准, 避免并发刷新相互覆盖
+			refreshed, changed := MergeSetCookie(tokens[i].Value, setCookies)
+			if !changed {
+				return nil, errUnchanged
+			}
+			if err := ValidateCookie(refreshed); err != nil {
+				return nil, err
+			}
+			tokens[i].Value = refreshed
+			return tokens, nil
+		}
+		return nil, ErrTokenNotFound
+	})
+	if errors.Is(err, errUnchanged) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,153 @@
+package cookiepool
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"genspark2api/common/config"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Selector cookie 选择策略, ids 为当前可用的 token ID, key 为粘性选择使用的标识
+type Selector interface {
+	Select(ids []string, key string) string
+}
+
+const (
+	StrategyRandom        = "random"
+	StrategyRoundRobin    = "round_robin"
+	StrategyLeastInFlight = "least_in_flight"
+	StrategyWeighted      = "weighted"
+	StrategySticky        = "sticky"
+)
+
+// NewSelector 按策略名称创建选择器, 未知策略使用随机选择
+func NewSelector(strategy string) Selector {
+	switch strategy {
+	case StrategyRoundRobin:
+		return &RoundRobinSelector{}
+	case StrategyLeastInFlight:
+		return &LeastInFlightSelector{InFlight: inFlightLocked}
+	case StrategyWeighted:
+		return &WeightedSelector{Weight: configWeight}
+	case StrategySticky:
+		return &StickySelector{Fallback: RandomSelector{}}
+	default:
+		return RandomSelector{}
+	}
+}
+
+// RandomSelector 随机选择
+type RandomSelector struct{}
+
+func (RandomSelector) Select(ids []string, key string) string {
+	return ids[rand.Intn(len(ids))]
+}
+
+// RoundRobinSelector 轮询选择
+type RoundRobinSelector struct {
+	counter atomic.Uint64
+}
+
+func (s *RoundRobinSelector) Select(ids []string, key string) string {
+	n := s.counter.Add(1) - 1
+	return ids[n%uint64(len(ids))]
+}
+
+// LeastInFlightSelector 选择进行中请求最少的 cookie, 数量相同时随机
+type LeastInFlightSelector struct {
+	InFlight func(id string) int64
+}
+
+func (s *LeastInFlightSelector) Select(ids []string, key string) string {
+	var candidates []string
+	least := int64(-1)
+	for _, id := range ids {
+		n := s.InFlight(id)
+		if least < 0 || n < least {
+			least = n
+			candidates = candidates[:0]
+		}
+		if n == least {
+			candidates = append(candidates, id)
+		}
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// WeightedSelector 按权重随机选择, 权重小于等于 0 的 cookie 不会被选中(全部为 0 时随机)
+type WeightedSelector struct {
+	Weight func(id string) int
+}
+
+func (s *WeightedSelector) Select(ids []string, key string) string {
+	total := 0
+	weights := make([]int, len(ids))
+	for i, id := range ids {
+		if w := s.Weight(id); w > 0 {
+			weights[i] = w
+			total += w
+		}
+	}
+	if total == 0 {
+		return ids[rand.Intn(len(ids))]
+	}
+	n := rand.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return ids[i]
+		}
+		n -= w
+	}
+	return ids[len(ids)-1]
+}
+
+// StickySelector 按 key 做最高随机权重哈希(rendezvous hashing), 相同 key 固定落到同一 cookie,
+// 池变化时只有原 cookie 不可用的 key 会被重新分配; key 为空时使用 Fallback
+type StickySelector struct {
+	Fallback Selector
+}
+
+func (s *StickySelector) Select(ids []string, key string) string {
+	if key == "" {
+		return s.Fallback.Select(ids, key)
+	}
+	var best string
+	var bestScore uint64
+	for i, id := range ids {
+		sum := sha256.Sum256([]byte(key + "|" + id))
+		score := binary.BigEndian.Uint64(sum[:8])
+		if i == 0 || score > bestScore {
+			best, bestScore = id, score
+		}
+	}
+	return best
+}
+
+var (
+	weightsOnce sync.Once
+	weights     map[string]int
+)
+
+// configWeight 读取 COOKIE_WEIGHTS 中配置的权重(格式: tokenID=权重), 未配置的 token 权重为 1
+func configWeight(id string) int {
+	weightsOnce.Do(func() {
+		weights = make(map[string]int)
+		for _, item := range config.CookieWeights {
+			parts := strings.SplitN(item, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if w, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil {
+				weights[strings.TrimSpace(parts[0])] = w
+			}
+		}
+	})
+	if w, ok := weights[id]; ok {
+		return w
+	}
+	return 1
+}
@@ -0,0 +1,220 @@
+package cookiepool
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// fixedSelector 总是返回指定 ID, 用于检查是否调用了 Fallback
+type fixedSelector string
+
+func (s fixedSelector) Select(ids []string, key string) string {
+	return string(s)
+}
+
+// countPicks 调用 n 次 Select 并统计每个 ID 被选中的次数
+func countPicks(s Selector, ids []string, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[s.Select(ids, "")]++
+	}
+	return counts
+}
+
+func TestNewSelector(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     Selector
+	}{
+		{StrategyRandom, RandomSelector{}},
+		{StrategyRoundRobin, &RoundRobinSelector{}},
+		{StrategyLeastInFlight, &LeastInFlightSelector{}},
+		{StrategyWeighted, &WeightedSelector{}},
+		{StrategySticky, &StickySelector{}},
+		{"", RandomSelector{}},
+		{"unknown", RandomSelector{}},
+	}
+	for _, tt := range tests {
+		got := NewSelector(tt.strategy)
+		if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+			t.Errorf("NewSelector(%q) = %T, want %T", tt.strategy, got, tt.want)
+		}
+	}
+}
+
+func TestRandomSelector(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	counts := countPicks(RandomSelector{}, ids, 3000)
+	if len(counts) != len(ids) {
+		t.Fatalf("picked %v, want every id", counts)
+	}
+	for _, id := range ids {
+		if counts[id] < 800 {
+			t.Errorf("id %s picked %d/3000 times", id, counts[id])
+		}
+	}
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []string
+		want []string
+	}{
+		{"single", []string{"a"}, []string{"a", "a", "a"}},
+		{"cycle", []string{"a", "b", "c"}, []string{"a", "b", "c", "a", "b", "c", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &RoundRobinSelector{}
+			var got []string
+			for range tt.want {
+				got = append(got, s.Select(tt.ids, "key"))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 候选列表变化时继续轮询, 不会越界
+	s := &RoundRobinSelector{}
+	s.Select([]string{"a", "b", "c"}, "")
+	s.Select([]string{"a", "b", "c"}, "")
+	if got := s.Select([]string{"a", "b"}, ""); got != "a" {
+		t.Fatalf("after shrinking got %s, want a", got)
+	}
+}
+
+func TestLeastInFlightSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		inFlight map[string]int64
+		ids      []string
+		want     []string
+	}{
+		{"single least", map[string]int64{"a": 3, "b": 1, "c": 2}, []string{"a", "b", "c"}, []string{"b"}},
+		{"idle wins", map[string]int64{"a": 1, "b": 1}, []string{"a", "b", "c"}, []string{"c"}},
+		{"tie", map[string]int64{"a": 2, "b": 1, "c": 1}, []string{"a", "b", "c"}, []string{"b", "c"}},
+		{"all idle", map[string]int64{}, []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &LeastInFlightSelector{InFlight: func(id string) int64 { return tt.inFlight[id] }}
+			counts := countPicks(s, tt.ids, 1000)
+			// 数量相同时在最少的 cookie 中随机, 每个都应被选中
+			if len(counts) != len(tt.want) {
+				t.Fatalf("picked %v, want only %v", counts, tt.want)
+			}
+			for _, id := range tt.want {
+				if counts[id] == 0 {
+					t.Fatalf("picked %v, want every one of %v", counts, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedSelector(t *testing.T) {
+	const n = 20000
+	tests := []struct {
+		name    string
+		weights map[string]int
+		ids     []string
+		want    map[string]float64
+	}{
+		{"equal", map[string]int{"a": 1, "b": 1}, []string{"a", "b"}, map[string]float64{"a": 0.5, "b": 0.5}},
+		{"skewed", map[string]int{"a": 3, "b": 1}, []string{"a", "b"}, map[string]float64{"a": 0.75, "b": 0.25}},
+		{"zero excluded", map[string]int{"a": 1, "b": 0, "c": 1}, []string{"a", "b", "c"}, map[string]float64{"a": 0.5, "c": 0.5}},
+		{"negative excluded", map[string]int{"a": -5, "b": 2}, []string{"a", "b"}, map[string]float64{"b": 1}},
+		{"all zero falls back to random", map[string]int{"a": 0, "b": 0, "c": 0}, []string{"a", "b", "c"}, map[string]float64{"a": 1.0 / 3, "b": 1.0 / 3, "c": 1.0 / 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &WeightedSelector{Weight: func(id string) int { return tt.weights[id] }}
+			counts := countPicks(s, tt.ids, n)
+			for _, id := range tt.ids {
+				got := float64(counts[id]) / n
+				if want := tt.want[id]; math.Abs(got-want) > 0.03 {
+					t.Errorf("id %s share %.3f, want %.3f", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStickySelector(t *testing.T) {
+	s := &StickySelector{Fallback: fixedSelector("fallback")}
+	ids := []string{"a", "b", "c", "d"}
+
+	// key 为空时使用 Fallback
+	if got := s.Select(ids, ""); got != "fallback" {
+		t.Fatalf("empty key picked %s, want fallback", got)
+	}
+
+	keys := make([]string, 1000)
+	assigned := make(map[string]string, len(keys))
+	used := make(map[string]bool)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user-%d", i)
+		assigned[keys[i]] = s.Select(ids, keys[i])
+		used[assigned[keys[i]]] = true
+	}
+	if len(used) != len(ids) {
+		t.Fatalf("keys spread over %v, want every id", used)
+	}
+
+	tests := []struct {
+		name string
+		ids  []string
+		// moved 返回 key 是否允许被重新分配, to 为重新分配后允许的 cookie
+		moved func(key, to string) bool
+		// maxMoved 允许重新分配的 key 的最大数量
+		maxMoved int
+	}{
+		{
+			name:  "same pool",
+			ids:   ids,
+			moved: func(key, to string) bool { return false },
+		},
+		{
+			name:  "reordered pool",
+			ids:   []string{"d", "c", "b", "a"},
+			moved: func(key, to string) bool { return false },
+		},
+		{
+			name: "cookie removed",
+			ids:  []string{"a", "c", "d"},
+			// 只有原来分配到 b 的 key 会被重新分配
+			moved:    func(key, to string) bool { return assigned[key] == "b" },
+			maxMoved: len(keys),
+		},
+		{
+			name: "cookie added",
+			ids:  []string{"a", "b", "c", "d", "e"},
+			// 重新分配的 key 只会落到新增的 cookie, 约占 1/5
+			moved:    func(key, to string) bool { return to == "e" },
+			maxMoved: 300,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moved := 0
+			for _, key := range keys {
+				got := s.Select(tt.ids, key)
+				if got == assigned[key] {
+					continue
+				}
+				if !tt.moved(key, got) {
+					t.Fatalf("key %s moved from %s to %s", key, assigned[key], got)
+				}
+				moved++
+			}
+			if moved > tt.maxMoved {
+				t.Fatalf("%d/%d keys moved, want at most %d", moved, len(keys), tt.maxMoved)
+			}
+		})
+	}
+}
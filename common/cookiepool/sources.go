@@ -0,0 +1,73 @@
+package cookiepool
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SourceCookies 从配置的各个来源读取 cookie 并去重: GS_COOKIE、GS_COOKIE_FILE、TOKEN_DIR,
+// 使用数据库存储时还包括 token 文件; 读取失败的来源会被跳过
+func SourceCookies() []string {
+	seen := make(map[string]bool)
+	var cookies []string
+	add := func(values []string, source string) {
+		for _, value := range values {
+			if seen[value] {
+				logger.SysLog(fmt.Sprintf("cookie %s 重复, 已忽略(来源: %s)", MaskCookie(value), source))
+				continue
+			}
+			seen[value] = true
+			cookies = append(cookies, value)
+			logger.SysLog(fmt.Sprintf("加载 cookie %s(来源: %s)", MaskCookie(value), source))
+		}
+	}
+
+	add(config.GSCookies, "GS_COOKIE")
+	if config.GSCookieFile != "" {
+		add(readSourceFile(config.GSCookieFile), "GS_COOKIE_FILE:"+config.GSCookieFile)
+	}
+	if config.TokenDir != "" {
+		for _, path := range listSourceDir(config.TokenDir) {
+			add(readSourceFile(path), "TOKEN_DIR:"+path)
+		}
+	}
+	if config.TokenStore != "" && config.TokenStore != "file" {
+		if _, err := os.Stat(config.TokenFilePath); err == nil {
+			add(readSourceFile(config.TokenFilePath), "token 文件:"+config.TokenFilePath)
+		}
+	}
+	return cookies
+}
+
+// readSourceFile 读取每行一个 cookie 的文件
+func readSourceFile(path string) []string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("无法读取 cookie 文件 %s: %v", path, err))
+		return nil
+	}
+	return ParseTokens(string(content))
+}
+
+// listSourceDir 返回目录下的所有普通文件, 忽略隐藏文件, 按文件名排序
+func listSourceDir(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("无法读取 cookie 目录 %s: %v", dir, err))
+		return nil
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(paths)
+	return paths
+}
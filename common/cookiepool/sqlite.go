@@ -0,0 +1,168 @@
+package cookiepool
+
+import (
+	"database/sql"
+	"strconv"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore 基于内嵌 SQLite 数据库的存储
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore 打开(必要时创建) SQLite 数据库
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite 不支持并发写入, 单连接避免 database is locked
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+CREATE TABLE IF NOT EXISTS tokens (
+	position   INTEGER PRIMARY KEY,
+	value      TEXT    NOT NULL UNIQUE,
+	label      TEXT    NOT NULL DEFAULT '',
+	disabled   INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL DEFAULT 0,
+	proxy      TEXT    NOT NULL DEFAULT '',
+	profile    TEXT    NOT NULL DEFAULT '',
+	id         TEXT    NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS token_imported (
+	digest TEXT PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS token_version (
+	id      INTEGER PRIMARY KEY CHECK (id = 1),
+	version INTEGER NOT NULL
+);
+INSERT OR IGNORE INTO token_version (id, version) VALUES (1, 0);`)
+	if err == nil {
+		err = migrateSQLite(db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// migrateSQLite 为旧版本创建的表补充新增的列
+func migrateSQLite(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('tokens')")
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	for _, column := range []string{"proxy", "profile", "id"} {
+		if columns[column] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE tokens ADD COLUMN " + column + " TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Load() ([]Token, error) {
+	rows, err := s.db.Query("SELECT id, value, label, disabled, created_at, proxy, profile FROM tokens ORDER BY position")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var token Token
+		if err := rows.Scan(&token.ID, &token.Value, &token.Label, &token.Disabled, &token.CreatedAt, &token.Proxy, &token.Profile); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// Save 在事务中整体替换 token 并递增版本
+func (s *SQLiteStore) Save(tokens []Token) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM tokens"); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT OR IGNORE INTO tokens (position, id, value, label, disabled, created_at, proxy, profile) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, token := range tokens {
+		if _, err := stmt.Exec(i, token.ID, token.Value, token.Label, token.Disabled, token.CreatedAt, token.Proxy, token.Profile); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE token_version SET version = version + 1 WHERE id = 1"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Version() (string, error) {
+	var version int64
+	if err := s.db.QueryRow("SELECT version FROM token_version WHERE id = 1").Scan(&version); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(version, 10), nil
+}
+
+func (s *SQLiteStore) Imported() ([]string, error) {
+	rows, err := s.db.Query("SELECT digest FROM token_imported")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var digests []string
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+	return digests, rows.Err()
+}
+
+func (s *SQLiteStore) AddImported(digests []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, digest := range digests {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO token_imported (digest) VALUES (?)", digest); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
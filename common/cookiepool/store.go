@@ -0,0 +1,352 @@
+package cookiepool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"genspark2api/common/fingerprint"
+	"genspark2api/common/helper"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrTokenExists   = errors.New("token already exists")
+	ErrTokenNotFound = errors.New("token not found")
+	ErrInvalidToken  = errors.New("invalid token")
+)
+
+// Token cookie 池中的 token 及其管理信息
+type Token struct {
+	// ID 添加时分配的稳定标识, cookie 刷新后保持不变
+	ID        string `json:"id"`
+	Value     string `json:"value"`
+	Label     string `json:"label"`
+	Disabled  bool   `json:"disabled"`
+	CreatedAt int64  `json:"created_at"`
+	// Proxy 绑定的上游代理, 为空时使用全局代理
+	Proxy string `json:"proxy"`
+	// Profile 绑定的浏览器指纹配置名称, 为空时使用全局配置
+	Profile string `json:"profile"`
+}
+
+// tokenList token 列表及按 ID 的索引
+type tokenList struct {
+	tokens []Token
+	byID   map[string]int
+}
+
+var (
+	// current 当前 token 列表快照, 整体替换以保证读取无锁且一致
+	current    atomic.Pointer[tokenList]
+	writeMutex sync.Mutex
+)
+
+// Tokens 返回所有 token(包括已停用的)的快照, 调用方不应修改返回的切片
+func Tokens() []Token {
+	p := current.Load()
+	if p == nil {
+		return nil
+	}
+	return p.tokens
+}
+
+// TokenByID 按ID查找 token
+func TokenByID(id string) (Token, bool) {
+	p := current.Load()
+	if p == nil {
+		return Token{}, false
+	}
+	i, ok := p.byID[id]
+	if !ok {
+		return Token{}, false
+	}
+	return p.tokens[i], true
+}
+
+// IDs 返回所有启用的 token 的ID, 进行中的请求继续使用已选中的 token
+func IDs() []string {
+	tokens := Tokens()
+	ids := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if !token.Disabled {
+			ids = append(ids, token.ID)
+		}
+	}
+	return ids
+}
+
+// newTokenID 为新 token 分配ID: 默认取添加时 cookie 的摘要(与旧版本按 cookie 计算的ID兼容), 已被占用时随机生成
+func newTokenID(value string, used map[string]bool) string {
+	if id := helper.CookieID(value); !used[id] {
+		return id
+	}
+	for {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		if id := hex.EncodeToString(b); !used[id] {
+			return id
+		}
+	}
+}
+
+// assignIDs 为缺少ID或ID重复的 token 分配ID, 返回是否有修改
+func assignIDs(tokens []Token) bool {
+	used := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		if token.ID != "" {
+			used[token.ID] = true
+		}
+	}
+	seen := make(map[string]bool, len(tokens))
+	changed := false
+	for i := range tokens {
+		if tokens[i].ID == "" || seen[tokens[i].ID] {
+			tokens[i].ID = newTokenID(tokens[i].Value, used)
+			used[tokens[i].ID] = true
+			changed = true
+		}
+		seen[tokens[i].ID] = true
+	}
+	return changed
+}
+
+// dedupeTokens 分配缺少的ID并去除空值及重复的 token, 返回是否有修改
+func dedupeTokens(tokens []Token) ([]Token, bool) {
+	changed := assignIDs(tokens)
+	seen := make(map[string]bool, len(tokens))
+	result := make([]Token, 0, len(tokens))
+	for _, token := range tokens {
+		if token.Value == "" || seen[token.Value] {
+			changed = true
+			continue
+		}
+		seen[token.Value] = true
+		result = append(result, token)
+	}
+	return result, changed
+}
+
+// storeTokens 去重后原子替换 token 列表, 调用方需持有写锁
+func storeTokens(tokens []Token) {
+	tokens, _ = dedupeTokens(tokens)
+	list := &tokenList{
+		tokens: tokens,
+		byID:   make(map[string]int, len(tokens)),
+	}
+	for i, token := range tokens {
+		list.byID[token.ID] = i
+	}
+	current.Store(list)
+	pruneHealths(list.byID)
+}
+
+// update 在写锁内基于当前列表生成新列表, 持久化成功后再生效
+func update(fn func(tokens []Token) ([]Token, error)) error {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	old := Tokens()
+	if backend != nil {
+		// 以后端中的最新数据为准, 避免覆盖其他实例的修改
+		latest, err := backend.Load()
+		if err != nil {
+			return err
+		}
+		old = latest
+	}
+	tokens, err := fn(append(make([]Token, 0, len(old)+1), old...))
+	if err != nil {
+		return err
+	}
+	// 去重后再持久化, 使后端保存的数据与内存中的列表一致
+	tokens, _ = dedupeTokens(tokens)
+	if backend != nil {
+		if err := backend.Save(tokens); err != nil {
+			return err
+		}
+	}
+	storeTokens(tokens)
+	return nil
+}
+
+// SetCookies 原子替换 cookie 池, 已存在的 cookie 保留原有的管理信息
+func SetCookies(cookies []string) {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	existing := make(map[string]Token)
+	for _, token := range Tokens() {
+		existing[token.Value] = token
+	}
+	tokens := make([]Token, 0, len(cookies))
+	for _, cookie := range cookies {
+		token, ok := existing[cookie]
+		if !ok {
+			token = Token{Value: cookie, CreatedAt: time.Now().Unix()}
+		}
+		tokens = append(tokens, token)
+	}
+	storeTokens(tokens)
+}
+
+// SetTokens 原子替换整个 token 列表
+func SetTokens(tokens []Token) {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+	storeTokens(tokens)
+}
+
+// ValidateCookie 检查 cookie 格式: 单行的 name=value 列表, 以分号分隔
+func ValidateCookie(cookie string) error {
+	if len(cookie) < 10 || len(cookie) > 16*1024 {
+		return fmt.Errorf("%w: length must be between 10 and 16384", ErrInvalidToken)
+	}
+	for _, r := range cookie {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("%w: contains control characters", ErrInvalidToken)
+		}
+	}
+	for _, part := range strings.Split(cookie, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, _, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(name) == "" || strings.ContainsAny(name, " \t\",") {
+			return fmt.Errorf("%w: malformed cookie pair %q", ErrInvalidToken, MaskCookie(part))
+		}
+	}
+	return nil
+}
+
+// AddToken 校验并追加 token, 重复时返回 ErrTokenExists
+func AddToken(value string) error {
+	value = strings.TrimSpace(value)
+	if err := ValidateCookie(value); err != nil {
+		return err
+	}
+	return update(func(tokens []Token) ([]Token, error) {
+		for _, token := range tokens {
+			if token.Value == value {
+				return nil, ErrTokenExists
+			}
+		}
+		return append(tokens, Token{Value: value, CreatedAt: time.Now().Unix()}), nil
+	})
+}
+
+// DeleteToken 按ID删除 token
+func DeleteToken(id string) error {
+	return update(func(tokens []Token) ([]Token, error) {
+		for i, token := range tokens {
+			if token.ID == id {
+				return append(tokens[:i], tokens[i+1:]...), nil
+			}
+		}
+		return nil, ErrTokenNotFound
+	})
+}
+
+// modifyToken 按ID修改 token 的管理信息
+func modifyToken(id string, fn func(token *Token)) error {
+	return update(func(tokens []Token) ([]Token, error) {
+		for i := range tokens {
+			if tokens[i].ID == id {
+				fn(&tokens[i])
+				return tokens, nil
+			}
+		}
+		return nil, ErrTokenNotFound
+	})
+}
+
+// SetTokenDisabled 启用或停用 token, 停用的 token 不会被选中
+func SetTokenDisabled(id string, disabled bool) error {
+	return modifyToken(id, func(token *Token) {
+		token.Disabled = disabled
+	})
+}
+
+// SetTokenLabel 设置 token 的标签/备注
+func SetTokenLabel(id string, label string) error {
+	return modifyToken(id, func(token *Token) {
+		token.Label = strings.TrimSpace(label)
+	})
+}
+
+// SetTokenProxy 为 token 绑定上游代理, 为空时使用全局代理
+func SetTokenProxy(id string, proxy string) error {
+	proxy = strings.TrimSpace(proxy)
+	if proxy != "" {
+		if err := ValidateProxy(proxy); err != nil {
+			return err
+		}
+	}
+	return modifyToken(id, func(token *Token) {
+		token.Proxy = proxy
+	})
+}
+
+// SetTokenProfile 为 token 绑定浏览器指纹配置, 使账号始终使用相同的指纹, 为空时使用全局配置
+func SetTokenProfile(id string, profile string) error {
+	profile = strings.TrimSpace(profile)
+	if profile != "" {
+		if _, ok := fingerprint.Get(profile); !ok {
+			return fmt.Errorf("%w: %s", fingerprint.ErrUnknownProfile, profile)
+		}
+	}
+	return modifyToken(id, func(token *Token) {
+		token.Profile = profile
+	})
+}
+
+// ClearTokens 清空所有 token
+func ClearTokens() error {
+	return update(func(tokens []Token) ([]Token, error) {
+		return nil, nil
+	})
+}
+
+// TokenView 管理接口返回的 token 信息, 不包含 cookie 原文
+type TokenView struct {
+	ID        string `json:"id"`
+	Masked    string `json:"masked"`
+	Label     string `json:"label"`
+	Disabled  bool   `json:"disabled"`
+	CreatedAt int64  `json:"created_at"`
+	Proxy     string `json:"proxy"`
+	Profile   string `json:"profile"`
+	Health    Health `json:"health"`
+}
+
+// List 返回所有 token 的脱敏信息及健康统计
+func List() []TokenView {
+	tokens := Tokens()
+	ids := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		ids = append(ids, token.ID)
+	}
+	healths := make(map[string]Health, len(tokens))
+	for _, h := range Snapshot(ids) {
+		healths[h.ID] = h
+	}
+
+	views := make([]TokenView, 0, len(tokens))
+	for _, token := range tokens {
+		views = append(views, TokenView{
+			ID:        token.ID,
+			Masked:    MaskCookie(token.Value),
+			Label:     token.Label,
+			Disabled:  token.Disabled,
+			CreatedAt: token.CreatedAt,
+			Proxy:     MaskProxy(token.Proxy),
+			Profile:   token.Profile,
+			Health:    healths[token.ID],
+		})
+	}
+	return views
+}
@@ -0,0 +1,364 @@
+package cookiepool
+
+import (
+	"database/sql"
+	"genspark2api/common/helper"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testStores 返回各存储后端的构造函数, 每次调用创建一个空的存储
+func testStores() map[string]func(t *testing.T) Store {
+	return map[string]func(t *testing.T) Store{
+		"file": func(t *testing.T) Store {
+			return NewFileStore(filepath.Join(t.TempDir(), "token.txt"))
+		},
+		"sqlite": func(t *testing.T) Store {
+			store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "token.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+		"redis": func(t *testing.T) Store {
+			server := miniredis.RunT(t)
+			store, err := NewRedisStore("redis://"+server.Addr()+"/0", "genspark2api:tokens")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	tokens := []Token{
+		{ID: "id-a", Value: "session_id=a", Label: "主账号", CreatedAt: 1700000000, Proxy: "http://127.0.0.1:8080", Profile: "chrome_131_mac"},
+		{ID: "id-b", Value: "session_id=b", Disabled: true, CreatedAt: 1700000001},
+	}
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+
+			loaded, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(loaded) != 0 {
+				t.Fatalf("empty store loaded %d tokens", len(loaded))
+			}
+
+			if err := store.Save(tokens); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err = store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, tokens) {
+				t.Fatalf("Load() = %+v, want %+v", loaded, tokens)
+			}
+
+			// 整体替换而不是追加
+			if err := store.Save(tokens[1:]); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err = store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, tokens[1:]) {
+				t.Fatalf("Load() after replace = %+v, want %+v", loaded, tokens[1:])
+			}
+		})
+	}
+}
+
+func TestStoreVersionChangesOnSave(t *testing.T) {
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			before, err := store.Version()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			versions := map[string]bool{before: true}
+			// 大小相同的修改及只修改管理信息也会改变版本, 文件存储的版本不依赖修改时间的精度
+			for _, tokens := range [][]Token{
+				{{ID: "a", Value: "session_id=a"}},
+				{{ID: "a", Value: "session_id=a"}, {ID: "b", Value: "session_id=b"}},
+				{{ID: "a", Value: "session_id=a"}, {ID: "b", Value: "session_id=c"}},
+				{{ID: "a", Value: "session_id=a", Label: "主账号"}, {ID: "b", Value: "session_id=c"}},
+			} {
+				if err := store.Save(tokens); err != nil {
+					t.Fatal(err)
+				}
+				version, err := store.Version()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if versions[version] {
+					t.Fatalf("version %q not bumped by Save", version)
+				}
+				versions[version] = true
+
+				// 读取不改变版本
+				if _, err := store.Load(); err != nil {
+					t.Fatal(err)
+				}
+				if again, _ := store.Version(); again != version {
+					t.Fatalf("version changed by Load: %q -> %q", version, again)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreImported(t *testing.T) {
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			imported, err := store.Imported()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(imported) != 0 {
+				t.Fatalf("empty store has imported %v", imported)
+			}
+
+			if err := store.AddImported([]string{"d1", "d2"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AddImported([]string{"d3"}); err != nil {
+				t.Fatal(err)
+			}
+			// 导入记录与 token 分开保存, 保存 token 不影响导入记录
+			if err := store.Save([]Token{{ID: "a", Value: "session_id=a"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			imported, err = store.Imported()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(imported)
+			if want := []string{"d1", "d2", "d3"}; !reflect.DeepEqual(imported, want) {
+				t.Fatalf("Imported() = %v, want %v", imported, want)
+			}
+		})
+	}
+}
+
+func TestSQLiteStoreMigratesColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.db")
+
+	// 旧版本创建的表没有 proxy、profile、id 列
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+CREATE TABLE tokens (
+	position   INTEGER PRIMARY KEY,
+	value      TEXT    NOT NULL UNIQUE,
+	label      TEXT    NOT NULL DEFAULT '',
+	disabled   INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE token_version (
+	id      INTEGER PRIMARY KEY CHECK (id = 1),
+	version INTEGER NOT NULL
+);
+INSERT INTO token_version (id, version) VALUES (1, 7);
+INSERT INTO tokens (position, value, label, disabled, created_at) VALUES (0, 'session_id=old', '旧账号', 1, 1700000000);`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Token{{Value: "session_id=old", Label: "旧账号", Disabled: true, CreatedAt: 1700000000}}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Fatalf("Load() = %+v, want %+v", loaded, want)
+	}
+	if version, _ := store.Version(); version != "7" {
+		t.Fatalf("Version() = %q, want 7", version)
+	}
+
+	// 新增的列可以正常写入
+	want[0].ID, want[0].Proxy, want[0].Profile = "id-old", "socks5://127.0.0.1:1080", "chrome_131_mac"
+	if err := store.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddImported([]string{"digest"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// 再次打开已迁移的数据库不重复迁移
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Fatalf("Load() after reopen = %+v, want %+v", loaded, want)
+	}
+	if imported, _ := store.Imported(); !reflect.DeepEqual(imported, []string{"digest"}) {
+		t.Fatalf("Imported() = %v, want [digest]", imported)
+	}
+}
+
+func TestFileStoreLegacyMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.txt")
+	store := NewFileStore(path)
+	if err := writeFileAtomic(path, []byte("session_id=a\n\nsession_id=b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 旧版本的管理信息没有 id
+	meta := `{"` + helper.CookieID("session_id=a") + `": {"label": "主账号"}}`
+	if err := writeFileAtomic(metaPath(path), []byte(meta), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Token{{Value: "session_id=a", Label: "主账号"}, {Value: "session_id=b"}}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Fatalf("Load() = %+v, want %+v", loaded, want)
+	}
+}
+
+func TestUseImportsSourceCookiesOnce(t *testing.T) {
+	defer SetTokens(nil)
+	defer func() { backend = nil }()
+
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+
+			if err := Use(store, []string{"session_id=a", "session_id=b"}); err != nil {
+				t.Fatal(err)
+			}
+			tokens, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := values(tokens); !reflect.DeepEqual(got, []string{"session_id=a", "session_id=b"}) {
+				t.Fatalf("first Use stored %v", got)
+			}
+			for _, token := range tokens {
+				if token.ID != helper.CookieID(token.Value) {
+					t.Fatalf("token %q has ID %q, want the cookie digest", token.Value, token.ID)
+				}
+			}
+
+			// 在管理后台删除 a, 并根据 Set-Cookie 将 b 刷新为新值
+			refreshed := tokens[1]
+			refreshed.Value = "session_id=b2"
+			if err := store.Save([]Token{refreshed}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Use(store, []string{"session_id=a", "session_id=b", "session_id=c"}); err != nil {
+				t.Fatal(err)
+			}
+			tokens, err = store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := values(tokens); !reflect.DeepEqual(got, []string{"session_id=b2", "session_id=c"}) {
+				t.Fatalf("second Use stored %v", got)
+			}
+			if tokens[0].ID != helper.CookieID("session_id=b") {
+				t.Fatalf("refreshed token ID changed to %q", tokens[0].ID)
+			}
+
+			imported, err := store.Imported()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(imported) != 3 {
+				t.Fatalf("Imported() = %v, want 3 digests", imported)
+			}
+			if got := values(Tokens()); !reflect.DeepEqual(got, []string{"session_id=b2", "session_id=c"}) {
+				t.Fatalf("pool has %v", got)
+			}
+		})
+	}
+}
+
+func TestUpdateSavesDeduplicatedTokens(t *testing.T) {
+	defer SetTokens(nil)
+	defer func() { backend = nil }()
+
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			// 手动修改存储导致的重复 token
+			if err := store.Save([]Token{
+				{ID: "a", Value: "session_id=a"},
+				{ID: "b", Value: "session_id=b"},
+				{ID: "a2", Value: "session_id=a"},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := Use(store, nil); err != nil {
+				t.Fatal(err)
+			}
+			tokens, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := values(tokens); !reflect.DeepEqual(got, []string{"session_id=a", "session_id=b"}) {
+				t.Fatalf("Use stored %v", got)
+			}
+
+			// 持久化的数据与内存中的列表一致, 按ID的修改作用于保留的 token
+			if err := update(func(tokens []Token) ([]Token, error) {
+				return append(tokens, Token{Value: "session_id=b"}, Token{Value: "session_id=c"}), nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			tokens, err = store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tokens, Tokens()) {
+				t.Fatalf("store has %+v, pool has %+v", tokens, Tokens())
+			}
+			if got := values(tokens); !reflect.DeepEqual(got, []string{"session_id=a", "session_id=b", "session_id=c"}) {
+				t.Fatalf("update stored %v", got)
+			}
+		})
+	}
+}
+
+func values(tokens []Token) []string {
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, token.Value)
+	}
+	return result
+}
@@ -3,6 +3,7 @@ package env
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 func Bool(env string, defaultValue bool) bool {
@@ -40,3 +41,16 @@ func String(env string, defaultValue string) string {
 	}
 	return os.Getenv(env)
 }
+
+func StringSlice(env string, defaultValue []string) []string {
+	if env == "" || os.Getenv(env) == "" {
+		return defaultValue
+	}
+	var result []string
+	for _, item := range strings.Split(os.Getenv(env), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package filestore
+
+import (
+	"encoding/json"
+	"genspark2api/common/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// File 已上传到 Genspark 私有存储的文件记录
+type File struct {
+	ID                string `json:"id"`
+	Filename          string `json:"filename"`
+	Purpose           string `json:"purpose"`
+	Bytes             int    `json:"bytes"`
+	MimeType          string `json:"mime_type"`
+	Ext               string `json:"ext"`
+	CreatedAt         int64  `json:"created_at"`
+	PrivateStorageURL string `json:"private_storage_url"`
+	// CookieID 上传该文件所用 token 的ID, 私有存储链接可能只对该账号有效
+	CookieID string `json:"cookie_id"`
+	// Owner 上传该文件的 API key 的摘要, 只有同一 API key 可以查看、删除及引用该文件
+	Owner string `json:"owner"`
+}
+
+var (
+	mutex  sync.Mutex
+	files  map[string]*File
+	loaded bool
+)
+
+// load 首次访问时从磁盘加载文件记录, 调用方需持有写锁
+func load() {
+	if loaded {
+		return
+	}
+	loaded = true
+	files = make(map[string]*File)
+
+	content, err := os.ReadFile(config.FileStorePath)
+	if err != nil {
+		return
+	}
+	var list []*File
+	if err := json.Unmarshal(content, &list); err != nil {
+		return
+	}
+	for _, file := range list {
+		files[file.ID] = file
+	}
+}
+
+// save 将文件记录写回磁盘, 调用方需持有写锁
+func save() error {
+	list := make([]*File, 0, len(files))
+	for _, file := range files {
+		list = append(list, file)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt < list[j].CreatedAt
+	})
+
+	content, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(config.FileStorePath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	// 先写临时文件再替换, 避免写入中断导致记录损坏
+	tmpPath := config.FileStorePath + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, config.FileStorePath)
+}
+
+// Add 保存文件记录
+func Add(file *File) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	load()
+	files[file.ID] = file
+	return save()
+}
+
+// Get 按ID获取文件记录
+func Get(id string) (*File, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	load()
+	file, ok := files[id]
+	return file, ok
+}
+
+// List 按创建时间倒序返回所有文件记录
+func List() []*File {
+	mutex.Lock()
+	defer mutex.Unlock()
+	load()
+	list := make([]*File, 0, len(files))
+	for _, file := range files {
+		list = append(list, file)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt > list[j].CreatedAt
+	})
+	return list
+}
+
+// Delete 删除文件记录, 返回记录是否存在
+func Delete(id string) (bool, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	load()
+	if _, ok := files[id]; !ok {
+		return false, nil
+	}
+	delete(files, id)
+	return true, save()
+}
@@ -0,0 +1,178 @@
+package fingerprint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/deanxv/CycleTLS/cycletls"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownProfile = errors.New("unknown browser profile")
+
+// Profile 浏览器指纹配置, 同一账号的所有上游请求应使用相同的配置
+type Profile struct {
+	Name        string   `json:"name"`
+	Ja3         string   `json:"ja3"`
+	UserAgent   string   `json:"user_agent"`
+	HeaderOrder []string `json:"header_order"`
+	// Headers 随浏览器变化的请求头, 如 sec-ch-ua
+	Headers map[string]string `json:"headers"`
+}
+
+const (
+	chromeJa3  = "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513,29-23-24,0"
+	firefoxJa3 = "771,4865-4867-4866-49195-49199-52393-52392-49196-49200-49162-49161-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-34-51-43-13-45-28-27-65037,29-23-24-25-256-257,0"
+	safariJa3  = "771,4865-4866-4867-49196-49195-52393-49200-49199-52392-49162-49161-49172-49171-157-156-53-47-49160-49170-10,0-23-65281-10-11-16-5-13-18-51-45-43-27-21,29-23-24-25,0"
+)
+
+var chromeHeaderOrder = []string{
+	"host", "connection", "content-length", "sec-ch-ua-platform", "user-agent", "sec-ch-ua", "content-type",
+	"sec-ch-ua-mobile", "accept", "origin", "sec-fetch-site", "sec-fetch-mode", "sec-fetch-dest", "referer",
+	"accept-encoding", "accept-language", "cookie", "priority",
+}
+
+var firefoxHeaderOrder = []string{
+	"host", "user-agent", "accept", "accept-language", "accept-encoding", "referer", "content-type", "content-length",
+	"origin", "connection", "cookie", "sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site", "priority", "te",
+}
+
+var safariHeaderOrder = []string{
+	"host", "content-type", "accept", "sec-fetch-site", "origin", "cookie", "content-length", "user-agent", "referer",
+	"sec-fetch-dest", "accept-language", "sec-fetch-mode", "accept-encoding", "connection", "priority",
+}
+
+// builtinProfiles 内置的浏览器指纹配置
+var builtinProfiles = []Profile{
+	{
+		Name:        "chrome_131_mac",
+		Ja3:         chromeJa3,
+		UserAgent:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+		HeaderOrder: chromeHeaderOrder,
+		Headers: map[string]string{
+			"sec-ch-ua":          `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"macOS"`,
+			"accept-language":    "en-US,en;q=0.9",
+		},
+	},
+	{
+		Name:        "chrome_131_windows",
+		Ja3:         chromeJa3,
+		UserAgent:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+		HeaderOrder: chromeHeaderOrder,
+		Headers: map[string]string{
+			"sec-ch-ua":          `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"Windows"`,
+			"accept-language":    "en-US,en;q=0.9",
+		},
+	},
+	{
+		Name:        "edge_131_windows",
+		Ja3:         chromeJa3,
+		UserAgent:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36 Edg/131.0.0.0",
+		HeaderOrder: chromeHeaderOrder,
+		Headers: map[string]string{
+			"sec-ch-ua":          `"Microsoft Edge";v="131", "Chromium";v="131", "Not_A Brand";v="24"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"Windows"`,
+			"accept-language":    "en-US,en;q=0.9",
+		},
+	},
+	{
+		Name:        "firefox_133_windows",
+		Ja3:         firefoxJa3,
+		UserAgent:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:133.0) Gecko/20100101 Firefox/133.0",
+		HeaderOrder: firefoxHeaderOrder,
+		Headers: map[string]string{
+			"accept-language": "en-US,en;q=0.5",
+		},
+	},
+	{
+		Name:        "safari_18_mac",
+		Ja3:         safariJa3,
+		UserAgent:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.1 Safari/605.1.15",
+		HeaderOrder: safariHeaderOrder,
+		Headers: map[string]string{
+			"accept-language": "en-US,en;q=0.9",
+		},
+	},
+}
+
+var (
+	mutex    sync.RWMutex
+	profiles = make(map[string]Profile)
+)
+
+func init() {
+	for _, profile := range builtinProfiles {
+		profiles[profile.Name] = profile
+	}
+}
+
+// LoadFile 从 JSON 文件(Profile 数组)加载自定义配置, 与内置配置同名时覆盖内置配置
+func LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var custom []Profile
+	if err := json.Unmarshal(content, &custom); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, profile := range custom {
+		if profile.Name == "" || profile.Ja3 == "" || profile.UserAgent == "" {
+			return fmt.Errorf("profile %q: name, ja3 and user_agent are required", profile.Name)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, profile := range custom {
+		profiles[profile.Name] = profile
+	}
+	return nil
+}
+
+// Get 按名称获取配置
+func Get(name string) (Profile, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	profile, ok := profiles[name]
+	return profile, ok
+}
+
+// Names 返回所有可用配置的名称
+func Names() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply 将配置应用到请求参数, 请求中已设置的同名请求头优先
+func (p Profile) Apply(options *cycletls.Options) {
+	options.Ja3 = p.Ja3
+	options.UserAgent = p.UserAgent
+	options.HeaderOrder = p.HeaderOrder
+	if options.Headers == nil {
+		options.Headers = make(map[string]string, len(p.Headers))
+	}
+	set := make(map[string]bool, len(options.Headers))
+	for key := range options.Headers {
+		set[strings.ToLower(key)] = true
+	}
+	for key, value := range p.Headers {
+		if !set[strings.ToLower(key)] {
+			options.Headers[key] = value
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// CookieID 返回 cookie 的摘要, 用于在不保存明文的情况下关联 cookie
+func CookieID(cookie string) string {
+	sum := sha256.Sum256([]byte(cookie))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,181 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"genspark2api/common/config"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// halves 生成左半红色、右半蓝色的图片, 用于检查方向
+func halves(width, height int, alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: 255, A: alpha}
+			if x >= width/2 {
+				c = color.NRGBA{B: 255, A: alpha}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeJPEG 编码 JPEG, orientation > 0 时在 SOI 之后插入带方向标签的 EXIF 段
+func encodeJPEG(t *testing.T, img image.Image, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if orientation == 0 {
+		return data
+	}
+	// 大端 TIFF 头, IFD0 只有一个方向标签
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, 0, 0, 0, 0}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	return append(append(append([]byte{}, data[:2]...), append(app1, segment...)...), data[2:]...)
+}
+
+func encodeGIF(t *testing.T, transparent bool) []byte {
+	t.Helper()
+	palette := color.Palette{color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	if transparent {
+		palette = append(palette, color.RGBA{})
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 30, 20), palette)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % len(palette))
+	}
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// oversizedGIF 生成逻辑屏幕尺寸超过像素上限的 GIF, 只有文件头声明的尺寸很大
+func oversizedGIF(t *testing.T) []byte {
+	t.Helper()
+	data := encodeGIF(t, false)
+	binary.LittleEndian.PutUint16(data[6:], 9000)
+	binary.LittleEndian.PutUint16(data[8:], 9000)
+	return data
+}
+
+// dominant 返回像素颜色中占主导的通道
+func dominant(img image.Image, x, y int) string {
+	r, g, b, _ := img.At(x, y).RGBA()
+	switch {
+	case r > g && r > b:
+		return "red"
+	case b > r && b > g:
+		return "blue"
+	}
+	return "other"
+}
+
+func TestProcess(t *testing.T) {
+	previous := []int{config.ImageMaxDimension, config.ImageJpegQuality}
+	config.ImageMaxDimension, config.ImageJpegQuality = 100, 85
+	defer func() { config.ImageMaxDimension, config.ImageJpegQuality = previous[0], previous[1] }()
+
+	tests := []struct {
+		name          string
+		data          []byte
+		mimeType      string
+		width, height int
+		// topLeft 输出图片左上角的颜色, 为空时不检查
+		topLeft string
+	}{
+		{"opaque png to jpeg", encodePNG(t, halves(40, 20, 255)), "image/jpeg", 40, 20, "red"},
+		{"transparent png stays png", encodePNG(t, halves(40, 20, 128)), "image/png", 40, 20, "red"},
+		{"resize landscape", encodePNG(t, halves(400, 200, 255)), "image/jpeg", 100, 50, "red"},
+		{"resize portrait", encodePNG(t, halves(50, 300, 255)), "image/jpeg", 16, 100, "red"},
+		{"jpeg without exif", encodeJPEG(t, halves(40, 20, 255), 0), "image/jpeg", 40, 20, "red"},
+		// 方向 6 需顺时针旋转 90 度: 左半部分转到上方
+		{"exif rotate 90", encodeJPEG(t, halves(40, 20, 255), 6), "image/jpeg", 20, 40, "red"},
+		// 方向 3 需旋转 180 度: 右半部分转到左侧
+		{"exif rotate 180", encodeJPEG(t, halves(40, 20, 255), 3), "image/jpeg", 40, 20, "blue"},
+		{"exif rotate 270", encodeJPEG(t, halves(40, 20, 255), 8), "image/jpeg", 20, 40, "blue"},
+		{"exif mirror", encodeJPEG(t, halves(40, 20, 255), 2), "image/jpeg", 40, 20, "blue"},
+		{"exif rotate and resize", encodeJPEG(t, halves(400, 200, 255), 6), "image/jpeg", 50, 100, "red"},
+		{"opaque gif to jpeg", encodeGIF(t, false), "image/jpeg", 30, 20, ""},
+		{"transparent gif to png", encodeGIF(t, true), "image/png", 30, 20, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, mimeType, err := Process(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mimeType != tt.mimeType {
+				t.Fatalf("mime type = %s, want %s", mimeType, tt.mimeType)
+			}
+			img, format, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if "image/"+format != mimeType {
+				t.Fatalf("output encoded as %s, reported %s", format, mimeType)
+			}
+			if bounds := img.Bounds(); bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+				t.Fatalf("output %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+			}
+			if tt.topLeft != "" {
+				if got := dominant(img, 1, 1); got != tt.topLeft {
+					t.Fatalf("top left is %s, want %s", got, tt.topLeft)
+				}
+			}
+			// 重新编码后不保留 EXIF
+			if bytes.Contains(data, []byte("Exif\x00\x00")) {
+				t.Fatal("output still contains EXIF")
+			}
+		})
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"empty", nil, "decode image config"},
+		{"not an image", []byte("hello, world"), "decode image config"},
+		{"truncated", encodePNG(t, halves(40, 20, 255))[:60], "decode image"},
+		// 超过解码像素上限时不解码像素数据
+		{"too many pixels", oversizedGIF(t), "image too large: 9000x9000"},
+	}
+	for _, tt := range tests {
+		if _, _, err := Process(tt.data); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: Process() = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDimensions(t *testing.T) {
+	width, height, err := Dimensions(oversizedGIF(t))
+	if err != nil || width != 9000 || height != 9000 {
+		t.Fatalf("Dimensions() = %d, %d, %v, want 9000x9000", width, height, err)
+	}
+}
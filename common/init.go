@@ -13,6 +13,8 @@ var (
 	PrintVersion = flag.Bool("version", false, "print version and exit")
 	PrintHelp    = flag.Bool("help", false, "print help and exit")
 	LogDir       = flag.String("log-dir", "", "specify the log directory")
+	// RotateTokenKey 使用当前密钥重新加密已存储的 token 后退出
+	RotateTokenKey = flag.Bool("rotate-token-key", false, "re-encrypt stored tokens with the current key and exit")
 )
 
 // UploadPath Maybe override by ENV_VAR
@@ -22,10 +24,17 @@ func printHelp() {
 	fmt.Println("genspark2api" + Version + "")
 	fmt.Println("Copyright (C) 2024 Dean. All rights reserved.")
 	//fmt.Println("GitHub: https://github.com/deanxv/genspark2api ")
-	fmt.Println("Usage: genspark2api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("Usage: genspark2api [--port <port>] [--log-dir <log directory>] [--rotate-token-key] [--version] [--help]")
 }
 
 func init() {
+	if os.Getenv("UPLOAD_PATH") != "" {
+		UploadPath = os.Getenv("UPLOAD_PATH")
+	}
+}
+
+// ParseFlags 解析命令行参数, 由 main 在启动时调用
+func ParseFlags() {
 	flag.Parse()
 
 	if *PrintVersion {
@@ -38,9 +47,6 @@ func init() {
 		os.Exit(0)
 	}
 
-	if os.Getenv("UPLOAD_PATH") != "" {
-		UploadPath = os.Getenv("UPLOAD_PATH")
-	}
 	if *LogDir != "" {
 		var err error
 		*LogDir, err = filepath.Abs(*LogDir)
@@ -0,0 +1,148 @@
+package common
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// mimeExtensions 常见文件类型与扩展名的对应关系
+var mimeExtensions = map[string]string{
+	"image/jpeg":         ".jpg",
+	"image/png":          ".png",
+	"image/gif":          ".gif",
+	"image/webp":         ".webp",
+	"image/bmp":          ".bmp",
+	"image/tiff":         ".tiff",
+	"image/svg+xml":      ".svg",
+	"application/pdf":    ".pdf",
+	"application/msword": ".doc",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": ".docx",
+	"application/vnd.ms-excel": ".xls",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         ".xlsx",
+	"application/vnd.ms-powerpoint":                                             ".ppt",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": ".pptx",
+	"application/json":          ".json",
+	"application/xml":           ".xml",
+	"application/zip":           ".zip",
+	"application/epub+zip":      ".epub",
+	"text/plain":                ".txt",
+	"text/csv":                  ".csv",
+	"text/tab-separated-values": ".tsv",
+	"text/markdown":             ".md",
+	"text/html":                 ".html",
+	"text/css":                  ".css",
+	"text/javascript":           ".js",
+	"text/x-python":             ".py",
+	"text/x-php":                ".php",
+	"text/x-lua":                ".lua",
+	"text/x-perl":               ".pl",
+	"text/x-go":                 ".go",
+	"text/x-java":               ".java",
+	"text/x-c":                  ".c",
+	"text/x-c++":                ".cpp",
+	"text/x-csharp":             ".cs",
+	"text/x-rust":               ".rs",
+	"text/x-ruby":               ".rb",
+	"text/x-kotlin":             ".kt",
+	"text/x-swift":              ".swift",
+	"text/x-sh":                 ".sh",
+	"text/x-sql":                ".sql",
+	"text/x-yaml":               ".yaml",
+	"text/x-typescript":         ".ts",
+}
+
+// extensionMimes 按扩展名识别的文件类型, 主要用于无法从内容区分的源码等纯文本文件
+var extensionMimes = map[string]string{
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".csv":      "text/csv",
+	".tsv":      "text/tab-separated-values",
+	".json":     "application/json",
+	".xml":      "application/xml",
+	".html":     "text/html",
+	".htm":      "text/html",
+	".css":      "text/css",
+	".js":       "text/javascript",
+	".mjs":      "text/javascript",
+	".jsx":      "text/javascript",
+	".ts":       "text/x-typescript",
+	".tsx":      "text/x-typescript",
+	".py":       "text/x-python",
+	".php":      "text/x-php",
+	".lua":      "text/x-lua",
+	".pl":       "text/x-perl",
+	".go":       "text/x-go",
+	".java":     "text/x-java",
+	".c":        "text/x-c",
+	".h":        "text/x-c",
+	".cpp":      "text/x-c++",
+	".cc":       "text/x-c++",
+	".hpp":      "text/x-c++",
+	".cs":       "text/x-csharp",
+	".rs":       "text/x-rust",
+	".rb":       "text/x-ruby",
+	".kt":       "text/x-kotlin",
+	".swift":    "text/x-swift",
+	".sh":       "text/x-sh",
+	".sql":      "text/x-sql",
+	".yaml":     "text/x-yaml",
+	".yml":      "text/x-yaml",
+	".txt":      "text/plain",
+	".log":      "text/plain",
+}
+
+// MimeExtension 返回文件类型对应的扩展名(包含点号), 未知类型返回空字符串
+func MimeExtension(mimeType string) string {
+	mimeType = baseMimeType(mimeType)
+	if ext, ok := mimeExtensions[mimeType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// DetectFileType 根据文件内容识别文件类型, 内容无法区分时参考文件名扩展名和声明的类型
+func DetectFileType(data []byte, filename string, declared string) (mimeType string, ext string) {
+	detected := mimetype.Detect(data)
+	mimeType = baseMimeType(detected.String())
+
+	// 纯文本和未知二进制无法从内容细分, 依次参考文件名与声明的类型
+	if mimeType == "text/plain" || mimeType == "application/octet-stream" {
+		if byExt, ok := extensionMimes[strings.ToLower(filepath.Ext(filename))]; ok {
+			mimeType = byExt
+		} else if declared = baseMimeType(declared); declared != "" && declared != "application/octet-stream" {
+			mimeType = declared
+		}
+	}
+
+	ext = MimeExtension(mimeType)
+	if ext == "" {
+		ext = detected.Extension()
+	}
+	return mimeType, ext
+}
+
+// FileNameWithExt 保留客户端提供的文件名, 未提供时按扩展名生成默认文件名
+func FileNameWithExt(filename string, ext string) string {
+	filename = strings.TrimSpace(filepath.Base(filename))
+	if filename != "" && filename != "." && filename != "/" {
+		return filename
+	}
+	return "file" + ext
+}
+
+func baseMimeType(mimeType string) string {
+	if mimeType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	}
+	return mediaType
+}
@@ -0,0 +1,177 @@
+package safefetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"genspark2api/common/config"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const maxRedirects = 5
+
+var (
+	ErrBlockedAddress = errors.New("blocked address")
+	ErrTooLarge       = errors.New("response too large")
+)
+
+// blockedPrefixes 除标准库可识别的私有/回环/链路本地地址外需要额外屏蔽的网段
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	// 6to4 和 Teredo 地址内嵌 IPv4 地址, 可经由中继访问内网
+	netip.MustParsePrefix("2002::/16"),
+	netip.MustParsePrefix("2001::/32"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+var client = &http.Client{
+	Transport: &http.Transport{
+		// 不使用环境变量中的代理, 否则代理会绕过地址检查
+		Proxy:                 nil,
+		DialContext:           dialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		MaxIdleConns:          20,
+		IdleConnTimeout:       90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return checkURL(req.URL)
+	},
+}
+
+// IsBlockedIP 判断地址是否属于禁止访问的内网或保留网段
+func IsBlockedIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialContext 解析域名后逐个检查地址, 并直接连接已检查的地址, 防止 DNS 重绑定
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var lastErr error = fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	for _, ip := range addrs {
+		if !config.FetchAllowPrivate && IsBlockedIP(ip) {
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// matchDomain 判断域名是否等于或属于列表中的某个域名
+func matchDomain(host string, domains []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL 检查协议和域名黑白名单, 地址检查在建立连接时进行
+func checkURL(u *url.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	allowed := false
+	for _, s := range config.FetchAllowedSchemes {
+		if strings.EqualFold(s, scheme) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("scheme %q is not allowed", u.Scheme)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if matchDomain(host, config.FetchDenyDomains) {
+		return fmt.Errorf("domain %s is denied", host)
+	}
+	if len(config.FetchAllowDomains) > 0 && !matchDomain(host, config.FetchAllowDomains) {
+		return fmt.Errorf("domain %s is not in the allow list", host)
+	}
+	return nil
+}
+
+// Fetch 安全地下载远程资源, 限制协议、目标地址、重定向、超时和大小
+func Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.FetchTimeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	maxBytes := int64(config.FetchMaxBytes)
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit %d", ErrTooLarge, resp.ContentLength, maxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: exceeds limit %d", ErrTooLarge, maxBytes)
+	}
+	return data, nil
+}
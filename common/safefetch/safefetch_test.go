@@ -0,0 +1,186 @@
+package safefetch
+
+import (
+	"context"
+	"errors"
+	"genspark2api/common/config"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// setConfig 修改下载相关配置, 并在测试结束后恢复
+func setConfig(t *testing.T, allowPrivate bool, allowDomains, denyDomains []string, maxBytes int) {
+	schemes, allow, deny, private, limit := config.FetchAllowedSchemes, config.FetchAllowDomains, config.FetchDenyDomains, config.FetchAllowPrivate, config.FetchMaxBytes
+	config.FetchAllowedSchemes = []string{"http", "https"}
+	config.FetchAllowPrivate, config.FetchAllowDomains, config.FetchDenyDomains, config.FetchMaxBytes = allowPrivate, allowDomains, denyDomains, maxBytes
+	t.Cleanup(func() {
+		config.FetchAllowedSchemes, config.FetchAllowDomains, config.FetchDenyDomains, config.FetchAllowPrivate, config.FetchMaxBytes = schemes, allow, deny, private, limit
+	})
+}
+
+func TestIsBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"8.8.8.8", false},
+		{"1.1.1.1", false},
+		{"2606:4700:4700::1111", false},
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"198.18.0.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"ff02::1", true},
+		// IPv4 映射地址按 IPv4 判断
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:8.8.8.8", false},
+		// NAT64、6to4、Teredo 地址内嵌 IPv4 地址
+		{"64:ff9b::7f00:1", true},
+		{"2002:7f00:1::1", true},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", true},
+		{"2001:db8::1", true},
+	}
+	for _, tt := range tests {
+		if got := IsBlockedIP(netip.MustParseAddr(tt.ip)); got != tt.blocked {
+			t.Errorf("IsBlockedIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		allowDomains []string
+		denyDomains  []string
+		ok           bool
+	}{
+		{"https", "https://example.com/a.png", nil, nil, true},
+		{"uppercase scheme", "HTTP://example.com/a.png", nil, nil, true},
+		{"file scheme", "file:///etc/passwd", nil, nil, false},
+		{"ftp scheme", "ftp://example.com/a.png", nil, nil, false},
+		{"gopher scheme", "gopher://example.com/", nil, nil, false},
+		{"missing host", "http:///a.png", nil, nil, false},
+		{"denied domain", "https://example.com/a.png", nil, []string{"example.com"}, false},
+		{"denied subdomain", "https://cdn.Example.com./a.png", nil, []string{".example.com"}, false},
+		{"denied suffix only", "https://notexample.com/a.png", nil, []string{"example.com"}, true},
+		{"allowed subdomain", "https://cdn.example.com/a.png", []string{"example.com"}, nil, true},
+		{"not allowed", "https://example.org/a.png", []string{"example.com"}, nil, false},
+		{"deny wins", "https://cdn.example.com/a.png", []string{"example.com"}, []string{"cdn.example.com"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, false, tt.allowDomains, tt.denyDomains, 1024)
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := checkURL(u); (err == nil) != tt.ok {
+				t.Fatalf("checkURL(%s) = %v, want ok=%v", tt.url, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFetchBlocksPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+
+	setConfig(t, false, nil, nil, 1024)
+	if _, err := Fetch(context.Background(), server.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Fetch(%s) = %v, want ErrBlockedAddress", server.URL, err)
+	}
+	// localhost 解析后同样被屏蔽
+	localhost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	if _, err := Fetch(context.Background(), localhost); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Fetch(%s) = %v, want ErrBlockedAddress", localhost, err)
+	}
+}
+
+func TestFetchRevalidatesRedirects(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file":
+			w.Write([]byte("ok"))
+		case "/scheme":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		case "/denied":
+			http.Redirect(w, r, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/file", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			http.Redirect(w, r, "/file", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	// 测试服务器位于回环地址, 允许内网地址后检查重定向目标的协议和域名;
+	// 重定向到内网地址与直接访问一样在建立连接时被屏蔽
+	setConfig(t, true, nil, []string{"localhost"}, 1024)
+	data, err := Fetch(context.Background(), server.URL+"/redirect")
+	if err != nil || string(data) != "ok" {
+		t.Fatalf("Fetch() = %q, %v, want ok", data, err)
+	}
+	for _, path := range []string{"/scheme", "/denied", "/loop"} {
+		if _, err := Fetch(context.Background(), server.URL+path); err == nil {
+			t.Errorf("Fetch(%s) followed the redirect", path)
+		}
+	}
+
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("x", 16)
+		if r.URL.Query().Has("chunked") {
+			// 不设置 Content-Length, 读取时截断
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		query    string
+		maxBytes int
+		ok       bool
+	}{
+		{"within limit", "", 16, true},
+		{"content length over limit", "", 15, false},
+		{"chunked within limit", "?chunked", 16, true},
+		{"chunked over limit", "?chunked", 15, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, true, nil, nil, tt.maxBytes)
+			data, err := Fetch(context.Background(), server.URL+"/"+tt.query)
+			if tt.ok {
+				if err != nil || len(data) != 16 {
+					t.Fatalf("Fetch() = %d bytes, %v", len(data), err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("Fetch() = %d bytes, %v, want ErrTooLarge", len(data), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"genspark2api/common"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"genspark2api/common/random"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// filePrefix 本包写入的文件名前缀, 清理时只删除本包写入的文件
+const filePrefix = "genspark-"
+
+// managedName 匹配 Save 生成的文件名, UPLOAD_PATH 中的其他文件不会被清理
+var managedName = regexp.MustCompile(`^` + filePrefix + `[0-9a-f]{32}(\.[A-Za-z0-9]+)?$`)
+
+var (
+	secretOnce sync.Once
+	secret     []byte
+)
+
+// signSecret 返回签名密钥, 未配置 FILE_SIGN_SECRET 时生成进程级随机密钥
+func signSecret() []byte {
+	secretOnce.Do(func() {
+		if config.FileSignSecret != "" {
+			secret = []byte(config.FileSignSecret)
+			return
+		}
+		logger.SysLog("FILE_SIGN_SECRET 未设置, 使用随机密钥, 重启后已签发的文件链接将失效")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	})
+	return secret
+}
+
+// Dir 返回本地文件存储目录
+func Dir() string {
+	return common.UploadPath
+}
+
+// Save 将字节写入存储目录, 返回生成的文件名
+func Save(data []byte, ext string) (string, error) {
+	if err := os.MkdirAll(Dir(), 0755); err != nil {
+		return "", err
+	}
+	name := filePrefix + random.GetUUID() + ext
+	if err := os.WriteFile(filepath.Join(Dir(), name), data, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Path 校验文件名并返回其在存储目录中的完整路径
+func Path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid file name: %s", name)
+	}
+	return filepath.Join(Dir(), name), nil
+}
+
+func sign(name string, expires int64) string {
+	mac := hmac.New(sha256.New, signSecret())
+	mac.Write([]byte(name + ":" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedURL 生成带过期时间和签名的文件访问链接
+func SignedURL(baseURL, name string) string {
+	expires := time.Now().Add(time.Duration(config.FileURLExpireSeconds) * time.Second).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", sign(name, expires))
+	return fmt.Sprintf("%s/files/%s?%s", strings.TrimSuffix(baseURL, "/"), url.PathEscape(name), query.Encode())
+}
+
+// Verify 校验签名是否有效且未过期
+func Verify(name, expiresStr, sig string) bool {
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sign(name, expires)), []byte(sig))
+}
+
+// StartCleanup 按保留策略定期删除本包写入的过期文件
+func StartCleanup() {
+	if config.FileRetentionHours <= 0 {
+		return
+	}
+	go func() {
+		for {
+			cleanup(time.Duration(config.FileRetentionHours) * time.Hour)
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func cleanup(retention time.Duration) {
+	entries, err := os.ReadDir(Dir())
+	if err != nil {
+		return
+	}
+	deadline := time.Now().Add(-retention)
+	removed := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !managedName.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(deadline) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(Dir(), entry.Name())); err == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		logger.SysLog(fmt.Sprintf("已清理 %d 个过期文件", removed))
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"genspark2api/common"
+	"genspark2api/common/config"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURL(t *testing.T) {
+	signed := SignedURL("https://example.com/", "genspark-a.png")
+	if !strings.HasPrefix(signed, "https://example.com/files/genspark-a.png?") {
+		t.Fatalf("SignedURL() = %q", signed)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires, sig := u.Query().Get("expires"), u.Query().Get("sig")
+	if until := time.Until(time.Unix(mustInt(t, expires), 0)); until <= 0 || until > time.Duration(config.FileURLExpireSeconds)*time.Second {
+		t.Fatalf("link expires in %s, want within FILE_URL_EXPIRE", until)
+	}
+
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	later := strconv.FormatInt(time.Now().Add(time.Hour*24*365).Unix(), 10)
+	tests := []struct {
+		name    string
+		file    string
+		expires string
+		sig     string
+		want    bool
+	}{
+		{"valid", "genspark-a.png", expires, sig, true},
+		{"other file", "genspark-b.png", expires, sig, false},
+		{"extended expiry", "genspark-a.png", later, sig, false},
+		{"tampered signature", "genspark-a.png", expires, strings.Repeat("0", len(sig)), false},
+		{"empty signature", "genspark-a.png", expires, "", false},
+		{"non-numeric expiry", "genspark-a.png", "soon", sig, false},
+		{"expired", "genspark-a.png", past, sign("genspark-a.png", mustInt(t, past)), false},
+	}
+	for _, tt := range tests {
+		if got := Verify(tt.file, tt.expires, tt.sig); got != tt.want {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../token.txt", "a/b.png", ".hidden"} {
+		if _, err := Path(name); err == nil {
+			t.Errorf("Path(%q) accepted an invalid name", name)
+		}
+	}
+	if _, err := Path("genspark-a.png"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	previous := common.UploadPath
+	common.UploadPath = t.TempDir()
+	defer func() { common.UploadPath = previous }()
+
+	expired, err := Save([]byte("old"), ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := Save([]byte("new"), ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// UPLOAD_PATH 中不是本包写入的文件不会被清理
+	others := []string{"notes.txt", strings.TrimPrefix(expired, filePrefix), filePrefix + "backup.png"}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range append(others, expired) {
+		path := filepath.Join(Dir(), name)
+		if name != expired {
+			if err := os.WriteFile(path, []byte("keep"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cleanup(time.Hour)
+	for _, name := range append(others, fresh) {
+		if _, err := os.Stat(filepath.Join(Dir(), name)); err != nil {
+			t.Errorf("%s removed: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(Dir(), expired)); !os.IsNotExist(err) {
+		t.Errorf("expired file %s not removed", expired)
+	}
+}
+
+func mustInt(t *testing.T, s string) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
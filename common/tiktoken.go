@@ -3,22 +3,63 @@ package common
 import (
 	logger "genspark2api/common/loggger"
 	"github.com/pkoukk/tiktoken-go"
+	"math"
+	"sync"
+	"unicode/utf8"
 )
 
 var (
-	Tke *tiktoken.Tiktoken
+	tkeOnce sync.Once
+	tke     *tiktoken.Tiktoken
 )
 
-func init() {
-	// gpt-4-turbo encoding
-	tke, err := tiktoken.GetEncoding("cl100k_base")
-	if err != nil {
-		logger.FatalLog(err.Error())
-	}
-	Tke = tke
+// encoding 首次使用时加载 gpt-4-turbo 使用的 cl100k_base 编码(可能需要联网下载), 加载失败时返回 nil
+func encoding() *tiktoken.Tiktoken {
+	tkeOnce.Do(func() {
+		var err error
+		if tke, err = tiktoken.GetEncoding("cl100k_base"); err != nil {
+			logger.SysError("加载 tiktoken 编码失败, 将按字符数估算 token: " + err.Error())
+		}
+	})
+	return tke
+}
+
+// LoadTokenizer 预先加载 token 编码, 避免首个请求等待下载
+func LoadTokenizer() {
+	encoding()
+}
 
+// TokensEstimated 返回 token 数是否为估算值(tiktoken 编码无法加载)
+func TokensEstimated() bool {
+	return encoding() == nil
 }
 
 func CountTokens(text string) int {
-	return len(Tke.Encode(text, nil, nil))
+	if e := encoding(); e != nil {
+		return len(e.Encode(text, nil, nil))
+	}
+	// 无法加载编码时按约 4 个字符一个 token 估算
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// CountImageTokens 按 OpenAI 视觉模型的分块规则估算图片 token 数.
+// low 固定 85; high/auto 先等比缩放到 2048x2048 以内, 再将短边缩放到 768,
+// 按 512x512 分块, 每块 170 加基础 85.
+func CountImageTokens(width, height int, detail string) int {
+	const baseTokens, tileTokens = 85, 170
+	if detail == "low" || width <= 0 || height <= 0 {
+		return baseTokens
+	}
+
+	w, h := float64(width), float64(height)
+	if w > 2048 || h > 2048 {
+		scale := 2048 / math.Max(w, h)
+		w, h = w*scale, h*scale
+	}
+	if shortest := math.Min(w, h); shortest > 768 {
+		scale := 768 / shortest
+		w, h = w*scale, h*scale
+	}
+	tiles := int(math.Ceil(w/512)) * int(math.Ceil(h/512))
+	return baseTokens + tileTokens*tiles
 }
@@ -0,0 +1,34 @@
+package common
+
+import "testing"
+
+func TestCountImageTokens(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		detail        string
+		want          int
+	}{
+		// OpenAI 文档中的示例
+		{"1024 square", 1024, 1024, "high", 765},
+		{"2048x4096", 2048, 4096, "high", 1105},
+		{"low", 4096, 8192, "low", 85},
+		{"auto", 1024, 1024, "auto", 765},
+		{"empty detail", 1024, 1024, "", 765},
+		{"small", 100, 100, "high", 255},
+		{"single tile", 512, 512, "high", 255},
+		{"just over a tile", 513, 512, "high", 425},
+		// 短边不足 768 时不放大
+		{"short side under 768", 768, 500, "high", 425},
+		{"short side 768", 1536, 768, "high", 1105},
+		// 先限制在 2048x2048 以内, 再将短边缩放到 768
+		{"clamped to 2048", 4096, 4096, "high", 765},
+		{"clamped wide", 8192, 1024, "high", 765},
+		{"unknown size", 0, 0, "high", 85},
+	}
+	for _, tt := range tests {
+		if got := CountImageTokens(tt.width, tt.height, tt.detail); got != tt.want {
+			t.Errorf("%s: CountImageTokens(%d, %d, %q) = %d, want %d", tt.name, tt.width, tt.height, tt.detail, got, tt.want)
+		}
+	}
+}
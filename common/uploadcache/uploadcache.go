@@ -0,0 +1,108 @@
+package uploadcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"genspark2api/common/config"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats 缓存命中统计
+type Stats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+	Bytes   int   `json:"bytes"`
+}
+
+type entry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+var (
+	mutex   sync.Mutex
+	entries = make(map[string]*list.Element)
+	lru     = list.New()
+	size    int
+	hits    atomic.Int64
+	misses  atomic.Int64
+)
+
+// Key 根据文件内容哈希和所属 token 的ID生成缓存键, 与 token 无关的内容传入空 cookieID
+func Key(data []byte, cookieID string) string {
+	sum := sha256.Sum256(data)
+	return cookieID + ":" + hex.EncodeToString(sum[:])
+}
+
+// Get 获取未过期的缓存值
+func Get(key string) (string, bool) {
+	if !config.UploadCacheEnable {
+		return "", false
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	element, ok := entries[key]
+	if !ok {
+		misses.Add(1)
+		return "", false
+	}
+	e := element.Value.(*entry)
+	if time.Now().After(e.expiresAt) {
+		removeElement(element)
+		misses.Add(1)
+		return "", false
+	}
+	lru.MoveToFront(element)
+	hits.Add(1)
+	return e.value, true
+}
+
+// Set 写入缓存, 超出条数或容量上限时淘汰最久未使用的条目
+func Set(key string, value string) {
+	if !config.UploadCacheEnable || len(value) > config.UploadCacheMaxBytes {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if element, ok := entries[key]; ok {
+		removeElement(element)
+	}
+	e := &entry{
+		key:       key,
+		value:     value,
+		expiresAt: time.Now().Add(time.Duration(config.UploadCacheTTL) * time.Second),
+	}
+	entries[key] = lru.PushFront(e)
+	size += len(value)
+
+	for lru.Len() > config.UploadCacheMaxEntries || size > config.UploadCacheMaxBytes {
+		removeElement(lru.Back())
+	}
+}
+
+// GetStats 返回当前的缓存统计
+func GetStats() Stats {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return Stats{
+		Hits:    hits.Load(),
+		Misses:  misses.Load(),
+		Entries: lru.Len(),
+		Bytes:   size,
+	}
+}
+
+// removeElement 移除条目, 调用方需持有锁
+func removeElement(element *list.Element) {
+	e := element.Value.(*entry)
+	lru.Remove(element)
+	delete(entries, e.key)
+	size -= len(e.value)
+}
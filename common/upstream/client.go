@@ -0,0 +1,390 @@
+package upstream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"genspark2api/common/config"
+	"io"
+	nethttp "net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	http "github.com/Danny-Dasilva/fhttp"
+	"github.com/deanxv/CycleTLS/cycletls"
+)
+
+var (
+	ErrClientClosed = errors.New("upstream client closed")
+	ErrBodyTooLarge = errors.New("response body too large")
+)
+
+// defaultTimeout 请求未指定超时时间时使用, 与 cycletls 保持一致
+const defaultTimeout = 15 * time.Second
+
+// Stats 连接池统计
+type Stats struct {
+	Transports int   `json:"transports"`
+	Requests   int64 `json:"requests"`
+	Streams    int64 `json:"streams"`
+	Active     int64 `json:"active"`
+}
+
+// Client 共享的上游客户端, 按指纹和代理复用连接, 可被多个 goroutine 同时使用.
+// 与 cycletls.Init 每次请求重新建立连接不同, 同一账号的请求会复用已建立的 TLS/HTTP2 连接
+type Client struct {
+	idleTimeout time.Duration
+
+	mutex      sync.Mutex
+	transports map[string]*transport
+	lastSweep  time.Time
+	closed     bool
+	streams    sync.WaitGroup
+
+	requests atomic.Int64
+	sse      atomic.Int64
+	active   atomic.Int64
+}
+
+// NewClient 创建上游客户端, idleTimeout 为空闲连接的保留时间
+func NewClient(idleTimeout time.Duration) *Client {
+	return &Client{
+		idleTimeout: idleTimeout,
+		transports:  make(map[string]*transport),
+	}
+}
+
+// transportFor 返回指纹和代理对应的连接池
+func (c *Client) transportFor(options cycletls.Options) (*transport, error) {
+	key := strings.Join([]string{options.Ja3, options.UserAgent, options.Proxy, boolKey(options.InsecureSkipVerify)}, "|")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	c.sweepLocked(time.Now())
+	if t, ok := c.transports[key]; ok {
+		return t, nil
+	}
+	t, err := newTransport(options.Ja3, options.UserAgent, options.Proxy, options.InsecureSkipVerify, c.idleTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.transports[key] = t
+	return t, nil
+}
+
+// sweepLocked 回收空闲超过 idleTimeout 的连接池, 避免更换代理或指纹后旧的连接池一直保留.
+// 每个 idleTimeout 周期最多检查一次
+func (c *Client) sweepLocked(now time.Time) {
+	if c.idleTimeout <= 0 || now.Sub(c.lastSweep) < c.idleTimeout {
+		return
+	}
+	c.lastSweep = now
+	for key, t := range c.transports {
+		if t.idleSince(now) >= c.idleTimeout {
+			delete(c.transports, key)
+			t.closeIdle()
+		}
+	}
+}
+
+func boolKey(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// send 按 cycletls 的规则构建请求(请求头顺序、伪首部顺序、User-Agent)并发送
+func (c *Client) send(ctx context.Context, url string, options cycletls.Options, method string) (*http.Response, error) {
+	t, err := c.transportFor(options)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), url, strings.NewReader(options.Body))
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(options.Headers))
+	for key := range options.Headers {
+		present[strings.ToLower(key)] = true
+	}
+	var headerOrder []string
+	for _, key := range options.HeaderOrder {
+		if key = strings.ToLower(key); present[key] {
+			headerOrder = append(headerOrder, key)
+		}
+	}
+	req.Header = http.Header{
+		http.HeaderOrderKey:  headerOrder,
+		http.PHeaderOrderKey: pseudoHeaderOrder(options.UserAgent),
+	}
+	for key, value := range options.Headers {
+		// Content-Length 由请求体决定
+		if !strings.EqualFold(key, "Content-Length") {
+			req.Header.Set(key, value)
+		}
+	}
+	req.Header.Set("User-Agent", options.UserAgent)
+
+	client := &http.Client{Transport: t}
+	if options.DisableRedirect {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	t.active.Add(1)
+	t.lastUsed.Store(time.Now().UnixNano())
+	resp, err := client.Do(req)
+	if err != nil {
+		t.active.Add(-1)
+		return nil, err
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, transport: t}
+	return resp, nil
+}
+
+// trackedBody 响应体关闭时结束连接池的进行中请求计数
+type trackedBody struct {
+	io.ReadCloser
+	transport *transport
+	once      sync.Once
+}
+
+func (b *trackedBody) Close() error {
+	b.once.Do(func() {
+		b.transport.lastUsed.Store(time.Now().UnixNano())
+		b.transport.active.Add(-1)
+	})
+	return b.ReadCloser.Close()
+}
+
+// requestContext 返回带请求超时的 context
+func requestContext(ctx context.Context, options cycletls.Options) (context.Context, context.CancelFunc) {
+	timeout := defaultTimeout
+	if options.Timeout > 0 {
+		timeout = time.Duration(options.Timeout) * time.Second
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Do 发送请求并读取完整响应, 返回值与 cycletls.CycleTLS.Do 兼容.
+// 与 cycletls 不同, 连接失败时返回 error 而不是带伪造状态码的响应
+func (c *Client) Do(ctx context.Context, url string, options cycletls.Options, method string) (cycletls.Response, error) {
+	c.requests.Add(1)
+	c.active.Add(1)
+	defer c.active.Add(-1)
+
+	ctx, cancel := requestContext(ctx, options)
+	defer cancel()
+	resp, err := c.send(ctx, url, options, method)
+	if err != nil {
+		return cycletls.Response{FinalUrl: url}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return cycletls.Response{Status: resp.StatusCode, FinalUrl: url}, err
+	}
+	return cycletls.Response{
+		Status:   resp.StatusCode,
+		Body:     cycletls.DecompressBody(body, resp.Header["Content-Encoding"], resp.Header["Content-Type"]),
+		Headers:  responseHeaders(resp.Header),
+		Cookies:  responseCookies(resp.Cookies()),
+		FinalUrl: resp.Request.URL.String(),
+	}, nil
+}
+
+// Download 发送 GET 请求并返回未经转换的响应体, 响应体超过 maxBytes 时返回 ErrBodyTooLarge
+func (c *Client) Download(ctx context.Context, url string, options cycletls.Options, maxBytes int64) (int, []byte, error) {
+	c.requests.Add(1)
+	c.active.Add(1)
+	defer c.active.Add(-1)
+
+	ctx, cancel := requestContext(ctx, options)
+	defer cancel()
+	resp, err := c.send(ctx, url, options, "GET")
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength > maxBytes {
+		return resp.StatusCode, nil, ErrBodyTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	if int64(len(body)) > maxBytes {
+		return resp.StatusCode, nil, ErrBodyTooLarge
+	}
+	return resp.StatusCode, body, nil
+}
+
+// DoSSE 发送请求并以事件流返回响应, 每个事件以 "data: " 开头, 最后一个事件的 Done 为 true.
+// ctx 取消后停止读取并关闭连接, 调用方提前停止消费时应取消 ctx
+func (c *Client) DoSSE(ctx context.Context, url string, options cycletls.Options, method string) (<-chan cycletls.SSEResponse, error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.streams.Add(1)
+	c.mutex.Unlock()
+	c.sse.Add(1)
+	c.active.Add(1)
+
+	ctx, cancel := requestContext(ctx, options)
+	resp, err := c.send(ctx, url, options, method)
+	if err != nil {
+		cancel()
+		c.active.Add(-1)
+		c.streams.Done()
+		return nil, err
+	}
+
+	events := make(chan cycletls.SSEResponse)
+	go func() {
+		defer c.streams.Done()
+		defer c.active.Add(-1)
+		defer cancel()
+		defer close(events)
+		defer resp.Body.Close()
+
+		send := func(data string, done bool) bool {
+			select {
+			case events <- cycletls.SSEResponse{Status: resp.StatusCode, Data: data, Done: done, FinalUrl: url}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		scanner.Split(splitEvents)
+		for scanner.Scan() {
+			if text := scanner.Text(); strings.TrimSpace(text) != "" && !send(text, false) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			send("Error reading stream: "+err.Error(), true)
+			return
+		}
+		send("", true)
+	}()
+	return events, nil
+}
+
+// splitEvents 以 "data: " 为分隔切分事件流, 与 cycletls.DoSSE 的切分方式一致
+func splitEvents(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.Index(data, []byte("data: ")); i >= 0 {
+		if next := bytes.Index(data[i+6:], []byte("data: ")); next >= 0 {
+			return i + 6 + next, data[i : i+6+next], nil
+		}
+		if atEOF {
+			return len(data), data[i:], nil
+		}
+	}
+	if !atEOF {
+		return 0, nil, nil
+	}
+	return len(data), data, nil
+}
+
+// responseHeaders 转换响应头, 多个 Set-Cookie 以 "/,/" 拼接, 与 cycletls 保持一致
+func responseHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if name == "Set-Cookie" {
+			headers[name] = strings.Join(values, "/,/")
+		} else if len(values) > 0 {
+			headers[name] = values[len(values)-1]
+		}
+	}
+	return headers
+}
+
+func responseCookies(cookies []*http.Cookie) []*nethttp.Cookie {
+	result := make([]*nethttp.Cookie, 0, len(cookies))
+	for _, cookie := range cookies {
+		result = append(result, &nethttp.Cookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			Expires:  cookie.Expires,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		})
+	}
+	return result
+}
+
+// CloseIdleConnections 关闭所有空闲连接, 进行中的请求不受影响
+func (c *Client) CloseIdleConnections() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, t := range c.transports {
+		t.closeIdle()
+	}
+}
+
+// Close 拒绝新请求, 等待进行中的事件流结束(最长至 ctx 取消)后关闭所有连接
+func (c *Client) Close(ctx context.Context) error {
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.streams.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	c.CloseIdleConnections()
+	return err
+}
+
+// Stats 返回连接池统计
+func (c *Client) Stats() Stats {
+	c.mutex.Lock()
+	transports := len(c.transports)
+	c.mutex.Unlock()
+	return Stats{
+		Transports: transports,
+		Requests:   c.requests.Load(),
+		Streams:    c.sse.Load(),
+		Active:     c.active.Load(),
+	}
+}
+
+var (
+	sharedOnce sync.Once
+	shared     *Client
+)
+
+// Shared 返回进程内共享的上游客户端, 由 main 在退出时关闭
+func Shared() *Client {
+	sharedOnce.Do(func() {
+		shared = NewClient(time.Duration(config.UpstreamIdleTimeout) * time.Second)
+	})
+	return shared
+}
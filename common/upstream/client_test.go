@@ -0,0 +1,260 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"genspark2api/common/fingerprint"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deanxv/CycleTLS/cycletls"
+)
+
+// newTestServer 启动启用 HTTP/2 的本地 TLS 服务, 并统计建立的连接数
+func newTestServer(t testing.TB, handler http.HandlerFunc) (*httptest.Server, *atomic.Int64) {
+	var conns atomic.Int64
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, &conns
+}
+
+// testOptions 返回使用默认浏览器指纹的请求参数, 本地服务使用自签名证书
+func testOptions(t testing.TB) cycletls.Options {
+	profile, ok := fingerprint.Get("chrome_131_mac")
+	if !ok {
+		t.Fatal("missing chrome_131_mac profile")
+	}
+	options := cycletls.Options{Timeout: 10, InsecureSkipVerify: true}
+	profile.Apply(&options)
+	return options
+}
+
+func hello(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("hello " + r.Proto))
+}
+
+func TestClientDoReusesConnection(t *testing.T) {
+	server, conns := newTestServer(t, hello)
+	client := NewClient(time.Minute)
+	defer client.Close(context.Background())
+
+	options := testOptions(t)
+	for i := 0; i < 5; i++ {
+		resp, err := client.Do(context.Background(), server.URL, options, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != http.StatusOK || resp.Body != "hello HTTP/2.0" {
+			t.Fatalf("got %d %q", resp.Status, resp.Body)
+		}
+	}
+	// 协商协议时建立的连接被复用, 所有请求共用一个连接
+	if n := conns.Load(); n != 1 {
+		t.Fatalf("server saw %d connections, want 1", n)
+	}
+	if stats := client.Stats(); stats.Transports != 1 || stats.Requests != 5 || stats.Active != 0 {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+}
+
+func TestClientDownloadLimit(t *testing.T) {
+	body := strings.Repeat("x", 1024)
+	server, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// 不设置 Content-Length, 只能在读取时检查大小
+		if r.URL.Path == "/chunked" {
+			w.Write([]byte(body[:512]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body[512:]))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(body))
+	})
+	client := NewClient(time.Minute)
+	defer client.Close(context.Background())
+	options := testOptions(t)
+
+	tests := []struct {
+		path     string
+		maxBytes int64
+		err      error
+	}{
+		{"/", 1024, nil},
+		{"/", 1023, ErrBodyTooLarge},
+		{"/chunked", 1024, nil},
+		{"/chunked", 600, ErrBodyTooLarge},
+	}
+	for _, tt := range tests {
+		status, data, err := client.Download(context.Background(), server.URL+tt.path, options, tt.maxBytes)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("Download(%s, %d) error = %v, want %v", tt.path, tt.maxBytes, err, tt.err)
+		}
+		// 图片按原样返回, 不像 Do 那样转为 base64
+		if err == nil && (status != http.StatusOK || string(data) != body) {
+			t.Fatalf("Download(%s, %d) = %d, %d bytes", tt.path, tt.maxBytes, status, len(data))
+		}
+	}
+	if active := client.Stats().Active; active != 0 {
+		t.Fatalf("%d requests still active", active)
+	}
+}
+
+func TestTransportHandshakeDoesNotBlockOtherHosts(t *testing.T) {
+	// 接受连接但不完成 TLS 握手的服务
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	go func() {
+		for {
+			conn, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	server, _ := newTestServer(t, hello)
+	client := NewClient(time.Minute)
+	defer client.Close(context.Background())
+	options := testOptions(t)
+
+	stalledDone := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, err := client.Do(ctx, "https://"+stalled.Addr().String(), options, "GET")
+		stalledDone <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// 同一指纹的连接池中, 其他地址的请求不等待未完成的握手
+	start := time.Now()
+	if _, err := client.Do(context.Background(), server.URL, options, "GET"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request blocked for %s by a stalled handshake", elapsed)
+	}
+
+	// 取消请求后立即返回, 不等待握手超时
+	cancel()
+	select {
+	case err := <-stalledDone:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("stalled request error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled request not cancelled")
+	}
+}
+
+func TestClientEvictsIdleTransports(t *testing.T) {
+	server, _ := newTestServer(t, hello)
+	client := NewClient(50 * time.Millisecond)
+	defer client.Close(context.Background())
+
+	// 不同的代理或指纹使用不同的连接池
+	options := testOptions(t)
+	other := options
+	other.UserAgent += " other"
+	for _, o := range []cycletls.Options{options, other} {
+		if _, err := client.Do(context.Background(), server.URL, o, "GET"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := client.Stats().Transports; n != 2 {
+		t.Fatalf("%d transports, want 2", n)
+	}
+
+	// 进行中的事件流所在的连接池不会被回收
+	events, err := client.DoSSE(context.Background(), server.URL, options, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := client.Do(context.Background(), server.URL, other, "GET"); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.Stats().Transports; n != 2 {
+		t.Fatalf("%d transports while a stream is open, want 2", n)
+	}
+	for range events {
+	}
+
+	// 空闲超过 idleTimeout 的连接池在下次请求时回收
+	time.Sleep(100 * time.Millisecond)
+	if _, err := client.Do(context.Background(), server.URL, other, "GET"); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.Stats().Transports; n != 1 {
+		t.Fatalf("%d transports after idle timeout, want 1", n)
+	}
+}
+
+// BenchmarkClientDo 共享客户端复用已建立的 TLS/HTTP2 连接
+func BenchmarkClientDo(b *testing.B) {
+	server, conns := newTestServer(b, hello)
+	client := NewClient(time.Minute)
+	defer client.Close(context.Background())
+	options := testOptions(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Do(context.Background(), server.URL, options, "GET"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+}
+
+// BenchmarkClientDoParallel 多个 goroutine 共用同一个连接池
+func BenchmarkClientDoParallel(b *testing.B) {
+	server, conns := newTestServer(b, hello)
+	client := NewClient(time.Minute)
+	defer client.Close(context.Background())
+	options := testOptions(b)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := client.Do(context.Background(), server.URL, options, "GET"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+}
+
+// BenchmarkCycleTLSInit 改用共享客户端之前的做法: 每次请求调用 cycletls.Init, 重新建立连接
+func BenchmarkCycleTLSInit(b *testing.B) {
+	server, conns := newTestServer(b, hello)
+	options := testOptions(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client := cycletls.Init()
+		resp, err := client.Do(server.URL, options, "GET")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if resp.Status != http.StatusOK {
+			b.Fatalf("status %d: %s", resp.Status, resp.Body)
+		}
+	}
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+}
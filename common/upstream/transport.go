@@ -0,0 +1,227 @@
+package upstream
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	http "github.com/Danny-Dasilva/fhttp"
+	http2 "github.com/Danny-Dasilva/fhttp/http2"
+	"github.com/deanxv/CycleTLS/cycletls"
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/proxy"
+)
+
+// dialTimeout 建立连接(含代理及 TLS 握手)的超时时间
+const dialTimeout = 30 * time.Second
+
+// transport 同一指纹和代理的连接池, 按目标地址协商 HTTP/2 或 HTTP/1.1 后复用连接
+type transport struct {
+	ja3         string
+	userAgent   string
+	insecure    bool
+	dialer      proxy.ContextDialer
+	idleTimeout time.Duration
+
+	mutex      sync.Mutex
+	transports map[string]http.RoundTripper
+	// negotiated 协商协议时建立的连接, 交给对应地址的首次拨号使用
+	negotiated map[string]net.Conn
+
+	// active 进行中的请求数(含未读完的响应体), lastUsed 最近一次请求的时间, 用于回收不再使用的连接池
+	active   atomic.Int64
+	lastUsed atomic.Int64
+}
+
+func newTransport(ja3, userAgent, proxyURL string, insecure bool, idleTimeout time.Duration) (*transport, error) {
+	dialer, err := newDialer(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	return &transport{
+		ja3:         ja3,
+		userAgent:   userAgent,
+		insecure:    insecure,
+		dialer:      dialer,
+		idleTimeout: idleTimeout,
+		transports:  make(map[string]http.RoundTripper),
+		negotiated:  make(map[string]net.Conn),
+	}, nil
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := dialAddr(req)
+	rt, err := t.roundTripper(req, addr)
+	if err != nil {
+		return nil, err
+	}
+	return rt.RoundTrip(req)
+}
+
+// roundTripper 返回目标地址对应的传输层, 首次请求时通过 ALPN 协商协议
+func (t *transport) roundTripper(req *http.Request, addr string) (http.RoundTripper, error) {
+	t.mutex.Lock()
+	if rt, ok := t.transports[addr]; ok {
+		t.mutex.Unlock()
+		return rt, nil
+	}
+	if req.URL.Scheme == "http" {
+		rt := &http.Transport{
+			DialContext:         t.dialer.DialContext,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     t.idleTimeout,
+		}
+		t.transports[addr] = rt
+		t.mutex.Unlock()
+		return rt, nil
+	}
+	t.mutex.Unlock()
+
+	// 协商在锁外进行, 不阻塞其他地址的请求; 使用独立的超时, 请求取消后协商出的连接仍可供后续请求使用
+	type result struct {
+		rt  http.RoundTripper
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rt, err := t.negotiate(addr)
+		done <- result{rt, err}
+	}()
+	select {
+	case r := <-done:
+		return r.rt, r.err
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// negotiate 建立到 addr 的连接并按 ALPN 协商结果创建传输层
+func (t *transport) negotiate(addr string) (http.RoundTripper, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err := t.handshake(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if rt, ok := t.transports[addr]; ok {
+		// 同一地址的并发首次请求已完成协商
+		conn.Close()
+		return rt, nil
+	}
+	var rt http.RoundTripper
+	if conn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		rt = &http2.Transport{
+			DialTLS: func(network, addr string, _ *utls.Config) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+				defer cancel()
+				return t.dialTLS(ctx, addr)
+			},
+			ReadIdleTimeout: t.idleTimeout,
+			Navigator:       navigator(t.userAgent),
+		}
+	} else {
+		rt = &http.Transport{
+			DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return t.dialTLS(ctx, addr)
+			},
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     t.idleTimeout,
+		}
+	}
+	t.transports[addr] = rt
+	t.negotiated[addr] = conn
+	return rt, nil
+}
+
+// dialTLS 建立使用指纹的 TLS 连接, 优先使用协商协议时建立的连接
+func (t *transport) dialTLS(ctx context.Context, addr string) (net.Conn, error) {
+	t.mutex.Lock()
+	conn, ok := t.negotiated[addr]
+	delete(t.negotiated, addr)
+	t.mutex.Unlock()
+	if ok {
+		return conn, nil
+	}
+	return t.handshake(ctx, addr)
+}
+
+func (t *transport) handshake(ctx context.Context, addr string) (*utls.UConn, error) {
+	rawConn, err := t.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	spec, err := cycletls.StringToSpec(t.ja3, t.userAgent, false)
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	conn := utls.UClient(rawConn, &utls.Config{ServerName: host, OmitEmptyPsk: true, InsecureSkipVerify: t.insecure}, utls.HelloCustom)
+	if err := conn.ApplyPreset(spec); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// idleSince 返回连接池在 now 时已空闲的时长, 有进行中的请求时返回 0
+func (t *transport) idleSince(now time.Time) time.Duration {
+	if t.active.Load() > 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, t.lastUsed.Load()))
+}
+
+// closeIdle 关闭空闲连接及未使用的协商连接
+func (t *transport) closeIdle() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for addr, conn := range t.negotiated {
+		conn.Close()
+		delete(t.negotiated, addr)
+	}
+	for _, rt := range t.transports {
+		if closer, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+}
+
+func dialAddr(req *http.Request) string {
+	if _, _, err := net.SplitHostPort(req.URL.Host); err == nil {
+		return req.URL.Host
+	}
+	if req.URL.Scheme == "http" {
+		return net.JoinHostPort(req.URL.Host, "80")
+	}
+	return net.JoinHostPort(req.URL.Host, "443")
+}
+
+// navigator 返回 HTTP/2 帧设置使用的浏览器类型
+func navigator(userAgent string) string {
+	if strings.Contains(strings.ToLower(userAgent), "firefox") {
+		return "firefox"
+	}
+	return "chrome"
+}
+
+// pseudoHeaderOrder 返回浏览器的 HTTP/2 伪首部顺序
+func pseudoHeaderOrder(userAgent string) []string {
+	if navigator(userAgent) == "firefox" {
+		return []string{":method", ":path", ":authority", ":scheme"}
+	}
+	return []string{":method", ":authority", ":scheme", ":path"}
+}
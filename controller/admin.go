@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"fmt"
+	"genspark2api/common/adminauth"
+	"genspark2api/common/config"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// isSecureRequest 判断请求是否经由 HTTPS(包括反向代理)
+func isSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+// Login 校验管理密码, 签发会话 cookie 及可用于 Authorization: Bearer 的令牌
+func (t *TokenController) Login(c *gin.Context) {
+	clientIP := c.ClientIP()
+	if remaining := adminauth.LockedFor(clientIP); remaining > 0 {
+		seconds := int(math.Ceil(remaining.Seconds()))
+		c.Header("Retry-After", fmt.Sprintf("%d", seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"code":    http.StatusTooManyRequests,
+			"message": fmt.Sprintf("登录失败次数过多, 请 %d 秒后重试", seconds),
+			"data":    nil,
+		})
+		return
+	}
+
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求参数",
+			"data":    nil,
+		})
+		return
+	}
+
+	if !adminauth.CheckPassword(req.Password) {
+		adminauth.RecordFailure(clientIP)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    http.StatusUnauthorized,
+			"message": "密码错误",
+			"data":    nil,
+		})
+		return
+	}
+	adminauth.RecordSuccess(clientIP)
+
+	session, expiresAt, err := adminauth.IssueSession()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "登录失败",
+			"data":    nil,
+		})
+		return
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(adminauth.CookieName, session, config.AdminSessionTTL, "/admin", "", isSecureRequest(c), true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "登录成功",
+		"data": gin.H{
+			"token":      session,
+			"csrf_token": adminauth.CSRFToken(session),
+			"expires_at": expiresAt.Unix(),
+		},
+	})
+}
+
+// Logout 注销当前会话
+func (t *TokenController) Logout(c *gin.Context) {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		adminauth.Revoke(strings.TrimPrefix(header, "Bearer "))
+	}
+	if session, err := c.Cookie(adminauth.CookieName); err == nil {
+		adminauth.Revoke(session)
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(adminauth.CookieName, "", -1, "/admin", "", isSecureRequest(c), true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已退出登录",
+		"data":    nil,
+	})
+}
+
+// LoginPage 返回管理后台登录页面
+func (t *TokenController) LoginPage(c *gin.Context) {
+	html := `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Token 管理系统 - 登录</title>
+    <style>
+        * { margin: 0; padding: 0; box-sizing: border-box; }
+        body {
+            font-family: "Microsoft YaHei", "微软雅黑", sans-serif;
+            background-color: #f7f1e6;
+            display: flex;
+            justify-content: center;
+            align-items: center;
+            min-height: 100vh;
+        }
+        .container {
+            background-color: white;
+            padding: 30px;
+            border-radius: 8px;
+            box-shadow: 0 4px 20px rgba(139, 69, 19, 0.1);
+            width: 360px;
+            border: 1px solid rgba(139, 69, 19, 0.2);
+        }
+        .title {
+            text-align: center;
+            color: #8b4513;
+            font-size: 24px;
+            margin-bottom: 25px;
+        }
+        input {
+            width: 100%;
+            padding: 10px;
+            border: 1px solid #d4a682;
+            border-radius: 4px;
+            font-size: 14px;
+            margin-bottom: 15px;
+            background-color: #fff9f0;
+        }
+        button {
+            width: 100%;
+            background-color: #8b4513;
+            color: white;
+            border: none;
+            padding: 10px;
+            border-radius: 4px;
+            cursor: pointer;
+            font-size: 16px;
+        }
+        .error { color: #a94442; margin-top: 10px; text-align: center; min-height: 20px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="title">Token 管理系统</div>
+        <form id="loginForm">
+            <input type="password" id="password" placeholder="请输入管理密码" autocomplete="current-password" autofocus>
+            <button type="submit">登录</button>
+        </form>
+        <div class="error" id="error"></div>
+    </div>
+
+    <script>
+        document.getElementById("loginForm").addEventListener("submit", async function(e) {
+            e.preventDefault();
+            const error = document.getElementById("error");
+            error.textContent = "";
+            try {
+                const response = await fetch("/admin/login", {
+                    method: "POST",
+                    headers: {"Content-Type": "application/json"},
+                    body: JSON.stringify({password: document.getElementById("password").value})
+                });
+                const data = await response.json();
+                if (data.code === 200) {
+                    window.location.reload();
+                } else {
+                    error.textContent = data.message || "登录失败";
+                }
+            } catch (err) {
+                error.textContent = "登录失败: " + err.message;
+            }
+        });
+    </script>
+</body>
+</html>`
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.String(http.StatusOK, html)
+}
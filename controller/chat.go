@@ -2,26 +2,32 @@ package controller
 
 import (
 	"bufio"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"genspark2api/common"
 	"genspark2api/common/config"
+	"genspark2api/common/cookiepool"
+	"genspark2api/common/imageproc"
+	logger "genspark2api/common/loggger"
+	"genspark2api/common/safefetch"
+	"genspark2api/common/uploadcache"
 	"genspark2api/model"
 	"github.com/deanxv/CycleTLS/cycletls"
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 	"io"
-	"io/ioutil"
 	"net/http"
+	neturl "net/url"
+	"path"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	baseURL          = "https://www.genspark.ai"
-	apiEndpoint      = baseURL + "/api/copilot/ask"
-	deleteEndpoint   = baseURL + "/api/project/delete?project_id=%s"
-	uploadEndpoint   = baseURL + "/api/get_upload_personal_image_url"
 	chatType         = "COPILOT_MOA_CHAT"
 	imageType        = "COPILOT_MOA_IMAGE"
 	responseIDFormat = "chatcmpl-%s"
@@ -37,145 +43,378 @@ type OpenAIChatCompletionRequest struct {
 	Model    string
 }
 
-func fetchImageAsBase64(url string) (string, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
+// attachmentTask 待处理的附件, 处理结果替换消息中原有的内容
+type attachmentTask struct {
+	messageIndex int
+	partIndex    int
+	process      func(ctx context.Context) (interface{}, error)
+}
 
-	return base64.StdEncoding.EncodeToString(data), nil
+// imageTokenUsage 单张图片的 token 估算明细
+type imageTokenUsage struct {
+	messageIndex int
+	partIndex    int
+	width        int
+	height       int
+	detail       string
+	tokens       int
 }
 
-func processMessages(c *gin.Context, cookie string, messages []model.OpenAIChatMessage) {
-	client := cycletls.Init()
+func processMessages(c *gin.Context, account cookiepool.Account, messages []model.OpenAIChatMessage) ([]*imageTokenUsage, error) {
 
+	var tasks []attachmentTask
+	var imageUsages []*imageTokenUsage
 	for i, message := range messages {
-		if contentArray, ok := message.Content.([]interface{}); ok {
-			for j, content := range contentArray {
-				if contentMap, ok := content.(map[string]interface{}); ok {
-					if contentType, ok := contentMap["type"].(string); ok && contentType == "image_url" {
-						if imageMap, ok := contentMap["image_url"].(map[string]interface{}); ok {
-							if url, ok := imageMap["url"].(string); ok {
-								processUrl(client, cookie, url, imageMap, j, contentArray)
-							}
-						}
+		contentArray, ok := message.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		for j, content := range contentArray {
+			contentMap, ok := content.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			contentType, _ := contentMap["type"].(string)
+			switch contentType {
+			case "image_url":
+				if imageMap, ok := contentMap["image_url"].(map[string]interface{}); ok {
+					if _, ok := imageMap["url"].(string); ok {
+						usage := &imageTokenUsage{messageIndex: i, partIndex: j}
+						imageUsages = append(imageUsages, usage)
+						tasks = append(tasks, attachmentTask{i, j, func(ctx context.Context) (interface{}, error) {
+							return processImagePart(ctx, account, contentMap, imageMap, usage)
+						}})
 					}
 				}
+			case "file":
+				// {"type":"file","file":{"file_data":"...","filename":"..."}}
+				if fileMap, ok := contentMap["file"].(map[string]interface{}); ok {
+					tasks = append(tasks, attachmentTask{i, j, func(ctx context.Context) (interface{}, error) {
+						return processFilePart(ctx, account, fileMap)
+					}})
+				}
+			case "input_file":
+				// {"type":"input_file","file_data":"...","filename":"..."}
+				tasks = append(tasks, attachmentTask{i, j, func(ctx context.Context) (interface{}, error) {
+					return processFilePart(ctx, account, contentMap)
+				}})
+			}
+		}
+	}
+
+	results, err := runAttachmentTasks(c.Request.Context(), tasks)
+	if err != nil {
+		return nil, err
+	}
+	// 按原位置替换, 保持内容顺序不变
+	for k, task := range tasks {
+		messages[task.messageIndex].Content.([]interface{})[task.partIndex] = results[k]
+	}
+	return imageUsages, nil
+}
+
+// runAttachmentTasks 并发处理附件, 任一附件失败时取消其余任务并返回错误
+func runAttachmentTasks(parent context.Context, tasks []attachmentTask) ([]interface{}, error) {
+	results := make([]interface{}, len(tasks))
+	if len(tasks) == 0 {
+		return results, nil
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	concurrency := config.AttachmentConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for k, task := range tasks {
+		wg.Add(1)
+		go func(k int, task attachmentTask) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
 			}
-			messages[i].Content = contentArray
+
+			result, err := runAttachmentTask(ctx, task)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("第 %d 条消息的第 %d 个附件处理失败: %v", task.messageIndex+1, task.partIndex+1, err)
+					cancel()
+				})
+				return
+			}
+			results[k] = result
+		}(k, task)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// runAttachmentTask 在超时时间内处理单个附件
+func runAttachmentTask(parent context.Context, task attachmentTask) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(parent, time.Duration(config.AttachmentTimeout)*time.Second)
+	defer cancel()
+
+	type taskResult struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan taskResult, 1)
+	go func() {
+		value, err := task.process(ctx)
+		done <- taskResult{value, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.value, result.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("处理超时(%ds)", config.AttachmentTimeout)
 		}
+		return nil, ctx.Err()
 	}
 }
-func processUrl(client cycletls.CycleTLS, cookie string, url string, imageMap map[string]interface{}, index int, contentArray []interface{}) {
+
+// loadAttachment 下载URL或解码base64数据, 返回文件内容、文件名及声明的类型
+func loadAttachment(ctx context.Context, url string, filename string) ([]byte, string, string, error) {
 	// 判断是否为URL
 	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
 		// 下载文件
-		bytes, err := fetchImageBytes(url)
+		bytes, err := safefetch.Fetch(ctx, url)
 		if err != nil {
-			fmt.Printf("下载文件失败: %v\n", err)
-			return
+			return nil, "", "", fmt.Errorf("下载文件失败: %v", err)
 		}
 
-		processBytes(client, cookie, bytes, imageMap, index, contentArray)
-	} else {
-		// 尝试解析base64
-		var bytes []byte
-		var err error
-
-		// 处理可能包含 data:image/ 前缀的base64
-		base64Str := url
-		if strings.Contains(url, ";base64,") {
-			base64Str = strings.Split(url, ";base64,")[1]
+		// 未提供文件名时尝试使用URL中的文件名
+		if filename == "" {
+			if parsed, err := neturl.Parse(url); err == nil && path.Ext(parsed.Path) != "" {
+				filename = path.Base(parsed.Path)
+			}
 		}
+		return bytes, filename, "", nil
+	}
 
-		bytes, err = base64.StdEncoding.DecodeString(base64Str)
-		if err != nil {
-			fmt.Printf("base64解码失败: %v\n", err)
-			return
-		}
+	// 处理可能包含 data:<mime>;base64, 前缀的base64
+	base64Str := url
+	declaredType := ""
+	if strings.Contains(url, ";base64,") {
+		parts := strings.SplitN(url, ";base64,", 2)
+		base64Str = parts[1]
+		declaredType = strings.TrimPrefix(parts[0], "data:")
+	}
 
-		processBytes(client, cookie, bytes, imageMap, index, contentArray)
+	if int64(base64.StdEncoding.DecodedLen(len(base64Str))) > int64(config.FetchMaxBytes)+2 {
+		return nil, "", "", fmt.Errorf("文件超过大小限制 %d 字节", config.FetchMaxBytes)
+	}
+	bytes, err := base64.StdEncoding.DecodeString(base64Str)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("base64解码失败: %v", err)
 	}
+	return bytes, filename, declaredType, nil
 }
 
-func processBytes(client cycletls.CycleTLS, cookie string, bytes []byte, imageMap map[string]interface{}, index int, contentArray []interface{}) {
-	// 检查是否为图片类型
-	contentType := http.DetectContentType(bytes)
-	if strings.HasPrefix(contentType, "image/") {
-		// 是图片类型，转换为base64
-		base64Data := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(bytes)
-		imageMap["url"] = base64Data
-	} else {
-		response, err := makeGetUploadUrlRequest(client, cookie)
-		if err != nil {
-			fmt.Printf("makeUploadRequest ERR: %v\n", err)
-			return
-		}
-
-		var jsonResponse map[string]interface{}
-		if err := json.Unmarshal([]byte(response.Body), &jsonResponse); err != nil {
-			fmt.Printf("Unmarshal ERR: %v\n", err)
-			return
-		}
+// processImagePart 处理 image_url 内容, 图片转为base64数据URL, 其他文件上传为 private_file
+func processImagePart(ctx context.Context, account cookiepool.Account, contentMap map[string]interface{}, imageMap map[string]interface{}, usage *imageTokenUsage) (interface{}, error) {
+	url, _ := imageMap["url"].(string)
+	filename, _ := imageMap["filename"].(string)
+	bytes, filename, declaredType, err := loadAttachment(ctx, url, filename)
+	if err != nil {
+		return nil, err
+	}
 
-		uploadImageUrl, ok := jsonResponse["data"].(map[string]interface{})["upload_image_url"].(string)
-		privateStorageUrl, ok := jsonResponse["data"].(map[string]interface{})["private_storage_url"].(string)
+	// 识别真实文件类型
+	contentType, ext := common.DetectFileType(bytes, filename, declaredType)
+	if !strings.HasPrefix(contentType, "image/") {
+		return uploadPrivateFile(ctx, account, bytes, filename, contentType, ext)
+	}
 
-		if !ok {
-			fmt.Println("Failed to extract upload_image_url")
-			return
-		}
+	// 按原始图片尺寸估算视觉 token
+	detail, _ := imageMap["detail"].(string)
+	if detail == "" {
+		detail = "auto"
+	}
+	if width, height, err := imageproc.Dimensions(bytes); err == nil {
+		usage.width, usage.height = width, height
+	}
+	usage.detail = detail
+	usage.tokens = common.CountImageTokens(usage.width, usage.height, detail)
 
-		// 发送OPTIONS预检请求
-		//_, err = makeOptionsRequest(client, uploadImageUrl)
-		//if err != nil {
-		//	return
-		//}
-		// 上传文件
-		_, err = makeUploadRequest(client, uploadImageUrl, bytes)
-		if err != nil {
-			fmt.Printf("makeUploadRequest ERR: %v\n", err)
-			return
-		}
-		//fmt.Println(resp)
-
-		// 创建新的 private_file 格式的内容
-		privateFile := map[string]interface{}{
-			"type": "private_file",
-			"private_file": map[string]interface{}{
-				"name":                "file", // 你可能需要从原始文件名或其他地方获取
-				"type":                contentType,
-				"size":                len(bytes),
-				"ext":                 strings.Split(contentType, "/")[1], // 简单处理，可能需要更复杂的逻辑
-				"private_storage_url": privateStorageUrl,
-			},
-		}
+	newImageMap := make(map[string]interface{}, len(imageMap))
+	for k, v := range imageMap {
+		newImageMap[k] = v
+	}
+	newImageMap["url"] = prepareImageDataURL(ctx, bytes, contentType)
 
-		// 替换数组中的元素
-		contentArray[index] = privateFile
+	newContentMap := make(map[string]interface{}, len(contentMap))
+	for k, v := range contentMap {
+		newContentMap[k] = v
 	}
+	newContentMap["image_url"] = newImageMap
+	return newContentMap, nil
 }
 
-// 获取文件字节数组的函数
-func fetchImageBytes(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+// processFilePart 处理 file/input_file 内容, 统一上传为 private_file
+func processFilePart(ctx context.Context, account cookiepool.Account, fileMap map[string]interface{}) (interface{}, error) {
+	// 引用 Files API 上传的文件, 无需重新上传
+	if fileID, ok := fileMap["file_id"].(string); ok && fileID != "" {
+		return privateFileFromStore(fileID, account)
+	}
+
+	filename, _ := fileMap["filename"].(string)
+	data, _ := fileMap["file_data"].(string)
+	if data == "" {
+		data, _ = fileMap["file_url"].(string)
+	}
+	if data == "" {
+		return nil, fmt.Errorf("file content part has no file_id, file_data or file_url")
+	}
+
+	bytes, filename, declaredType, err := loadAttachment(ctx, data, filename)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	return ioutil.ReadAll(resp.Body)
+	contentType, ext := common.DetectFileType(bytes, filename, declaredType)
+	return uploadPrivateFile(ctx, account, bytes, filename, contentType, ext)
+}
+
+// prepareImageDataURL 将图片转换为base64数据URL, 相同内容直接复用缓存
+func prepareImageDataURL(ctx context.Context, bytes []byte, contentType string) string {
+	cacheKey := uploadcache.Key(bytes, "")
+	if dataURL, ok := uploadcache.Get(cacheKey); ok {
+		return dataURL
+	}
+	// 缩放、重新压缩并去除元数据, 无法解码的图片按原样发送
+	if config.ImagePreprocessEnable {
+		if processed, processedType, err := imageproc.Process(bytes); err == nil {
+			bytes, contentType = processed, processedType
+		} else {
+			logger.Errorf(ctx, "图片预处理失败: %v", err)
+		}
+	}
+	// 是图片类型，转换为base64
+	dataURL := "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(bytes)
+	uploadcache.Set(cacheKey, dataURL)
+	return dataURL
 }
 
-func createRequestBody(c *gin.Context, cookie string, openAIReq *model.OpenAIChatCompletionRequest) map[string]interface{} {
-	// 处理消息中的图像 URL
-	processMessages(c, cookie, openAIReq.Messages)
+// uploadPrivateFile 上传文件到 Genspark 私有存储, 返回 private_file 格式的内容
+func uploadPrivateFile(ctx context.Context, account cookiepool.Account, bytes []byte, filename string, contentType string, ext string) (map[string]interface{}, error) {
+	// 同一 cookie 上传过相同内容时直接复用私有存储链接
+	cacheKey := uploadcache.Key(bytes, account.ID)
+	if privateStorageUrl, ok := uploadcache.Get(cacheKey); ok {
+		return buildPrivateFile(bytes, filename, contentType, ext, privateStorageUrl), nil
+	}
+
+	response, err := makeGetUploadUrlRequest(ctx, account)
+	if err != nil {
+		return nil, fmt.Errorf("makeGetUploadUrlRequest ERR: %v", err)
+	}
+
+	var jsonResponse map[string]interface{}
+	if err := json.Unmarshal([]byte(response.Body), &jsonResponse); err != nil {
+		return nil, fmt.Errorf("Unmarshal ERR: %v", err)
+	}
+
+	data, _ := jsonResponse["data"].(map[string]interface{})
+	uploadImageUrl, ok1 := data["upload_image_url"].(string)
+	privateStorageUrl, ok2 := data["private_storage_url"].(string)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("Failed to extract upload_image_url")
+	}
+
+	// 上传文件
+	uploadResponse, err := makeUploadRequest(ctx, account, uploadImageUrl, bytes)
+	if err != nil {
+		return nil, fmt.Errorf("makeUploadRequest ERR: %v", err)
+	}
+	if uploadResponse.Status < 200 || uploadResponse.Status >= 300 {
+		return nil, fmt.Errorf("makeUploadRequest ERR: status %d", uploadResponse.Status)
+	}
+
+	uploadcache.Set(cacheKey, privateStorageUrl)
+
+	return buildPrivateFile(bytes, filename, contentType, ext, privateStorageUrl), nil
+}
+
+// buildPrivateFile 创建新的 private_file 格式的内容
+func buildPrivateFile(bytes []byte, filename string, contentType string, ext string, privateStorageUrl string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "private_file",
+		"private_file": map[string]interface{}{
+			"name":                common.FileNameWithExt(filename, ext),
+			"type":                contentType,
+			"size":                len(bytes),
+			"ext":                 strings.TrimPrefix(ext, "."),
+			"private_storage_url": privateStorageUrl,
+		},
+	}
+}
+
+// countTextTokens 统计消息中的文本 token, 每条消息额外计 3 个, 回复另计 3 个
+func countTextTokens(messages []model.OpenAIChatMessage) int {
+	tokens := 3
+	for _, message := range messages {
+		tokens += 3 + common.CountTokens(message.Role)
+		switch content := message.Content.(type) {
+		case string:
+			tokens += common.CountTokens(content)
+		case []interface{}:
+			for _, part := range content {
+				if partMap, ok := part.(map[string]interface{}); ok && partMap["type"] == "text" {
+					if text, ok := partMap["text"].(string); ok {
+						tokens += common.CountTokens(text)
+					}
+				}
+			}
+		}
+	}
+	return tokens
+}
+
+// warnEstimatedUsage tiktoken 编码无法加载时 usage 按字符数估算, 记录日志以便核对计费
+func warnEstimatedUsage(c *gin.Context, usage model.OpenAIUsage) {
+	if common.TokensEstimated() {
+		logger.Warnf(c.Request.Context(), "tiktoken 不可用, usage 为估算值: prompt=%d completion=%d total=%d",
+			usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+	}
+}
+
+func createRequestBody(c *gin.Context, account cookiepool.Account, openAIReq *model.OpenAIChatCompletionRequest) (map[string]interface{}, int, error) {
+	textTokens := countTextTokens(openAIReq.Messages)
+
+	// 处理消息中的图像和文件
+	imageUsages, err := processMessages(c, account, openAIReq.Messages)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	imageTokens := 0
+	for _, usage := range imageUsages {
+		imageTokens += usage.tokens
+		logger.Infof(c.Request.Context(), "vision tokens: message=%d part=%d size=%dx%d detail=%s tokens=%d",
+			usage.messageIndex+1, usage.partIndex+1, usage.width, usage.height, usage.detail, usage.tokens)
+	}
+	logger.Infof(c.Request.Context(), "prompt tokens: text=%d image=%d images=%d total=%d",
+		textTokens, imageTokens, len(imageUsages), textTokens+imageTokens)
 
 	// 创建请求体
 	return map[string]interface{}{
@@ -189,10 +428,10 @@ func createRequestBody(c *gin.Context, cookie string, openAIReq *model.OpenAICha
 			"run_with_another_model": false,
 			"writingContent":         nil,
 		},
-	}
+	}, textTokens + imageTokens, nil
 }
 
-func createImageRequestBody(c *gin.Context, cookie string, openAIReq *model.OpenAIImagesGenerationRequest) map[string]interface{} {
+func createImageRequestBody(c *gin.Context, account cookiepool.Account, openAIReq *model.OpenAIImagesGenerationRequest) map[string]interface{} {
 
 	if openAIReq.Model == "dall-e-3" {
 		openAIReq.Model = "dalle-3"
@@ -252,10 +491,18 @@ func createStreamResponse(responseId, modelName string, delta model.OpenAIDelta,
 }
 
 // handleStreamResponse 处理流式响应
-func handleStreamResponse(c *gin.Context, sseChan <-chan cycletls.SSEResponse, responseId, cookie, model string) bool {
+func handleStreamResponse(c *gin.Context, sseChan <-chan cycletls.SSEResponse, responseId string, account cookiepool.Account, modelName string, promptTokens int) bool {
 	var projectId string
+	var completion strings.Builder
+	start := time.Now()
+	reported := false
 
 	for response := range sseChan {
+		// 以首个事件的状态码记录 cookie 健康状态
+		if !reported {
+			reported = true
+			reportUpstream(account, response.Status, start, nil)
+		}
 		if response.Done {
 			break
 		}
@@ -286,25 +533,30 @@ func handleStreamResponse(c *gin.Context, sseChan <-chan cycletls.SSEResponse, r
 		case "project_start":
 			projectId, _ = event["id"].(string)
 		case "message_field_delta":
-			if err := handleMessageFieldDelta(c, event, responseId, model); err != nil {
+			if err := handleMessageFieldDelta(c, event, responseId, modelName, &completion); err != nil {
 				return false
 			}
 		case "message_result":
 			// 删除临时会话
 			if config.AutoDelChat == 1 {
 				go func() {
-					c := cycletls.Init()
-					makeDeleteRequest(c, cookie, projectId)
+					// 请求结束后 context 会被取消, 删除请求使用独立的 context
+					makeDeleteRequest(context.Background(), account, projectId)
 				}()
 			}
-			return handleMessageResult(c, responseId, model)
+			completionTokens := common.CountTokens(completion.String())
+			return handleMessageResult(c, responseId, modelName, &model.OpenAIUsage{
+				PromptTokens:     promptTokens,
+				CompletionTokens: completionTokens,
+				TotalTokens:      promptTokens + completionTokens,
+			})
 		}
 	}
 	return false
 }
 
 // handleMessageFieldDelta 处理消息字段增量
-func handleMessageFieldDelta(c *gin.Context, event map[string]interface{}, responseId, modelName string) error {
+func handleMessageFieldDelta(c *gin.Context, event map[string]interface{}, responseId, modelName string, completion *strings.Builder) error {
 	fieldName, ok := event["field_name"].(string)
 	if !ok || fieldName != "session_state.answer" {
 		return nil
@@ -315,16 +567,21 @@ func handleMessageFieldDelta(c *gin.Context, event map[string]interface{}, respo
 	if !ok {
 		return nil
 	}
+	completion.WriteString(delta)
 
 	streamResp := createStreamResponse(responseId, modelName, model.OpenAIDelta{Content: delta, Role: "assistant"}, nil)
 	return sendSSEvent(c, streamResp)
 }
 
-// handleMessageResult 处理消息结果
-func handleMessageResult(c *gin.Context, responseId, modelName string) bool {
+// handleMessageResult 处理消息结果, usage 不为 nil 时随最后一个分块返回
+func handleMessageResult(c *gin.Context, responseId, modelName string, usage *model.OpenAIUsage) bool {
 	finishReason := "stop"
 
 	streamResp := createStreamResponse(responseId, modelName, model.OpenAIDelta{}, &finishReason)
+	if usage != nil {
+		streamResp.Usage = *usage
+		warnEstimatedUsage(c, *usage)
+	}
 	if err := sendSSEvent(c, streamResp); err != nil {
 		return false
 	}
@@ -344,111 +601,52 @@ func sendSSEvent(c *gin.Context, response model.OpenAIChatCompletionResponse) er
 }
 
 // makeRequest 发送HTTP请求
-func makeRequest(client cycletls.CycleTLS, jsonData []byte, cookie string, isStream bool) (cycletls.Response, error) {
+func makeRequest(ctx context.Context, jsonData []byte, account cookiepool.Account, isStream bool) (cycletls.Response, error) {
 	accept := "application/json"
 	if isStream {
 		accept = "text/event-stream"
 	}
 
-	return client.Do(apiEndpoint, cycletls.Options{
-		Timeout: 10 * 60 * 60,
-		Body:    string(jsonData),
-		Method:  "POST",
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-			"Accept":       accept,
-			"Origin":       baseURL,
-			"Referer":      baseURL + "/",
-			"Cookie":       cookie,
-		},
-	}, "POST")
+	start := time.Now()
+	response, err := genspark.Ask(ctx, account, jsonData, accept)
+	reportUpstream(account, response.Status, start, err)
+	if err == nil {
+		refreshCookie(account, response)
+	}
+	return response, err
 }
 
 // makeRequest 发送HTTP请求
-func makeImageRequest(client cycletls.CycleTLS, jsonData []byte, cookie string) (cycletls.Response, error) {
-	accept := "*/*"
-
-	return client.Do(apiEndpoint, cycletls.Options{
-		Timeout: 10 * 60 * 60,
-		Body:    string(jsonData),
-		Method:  "POST",
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-			"Accept":       accept,
-			"Origin":       baseURL,
-			"Referer":      baseURL + "/",
-			"Cookie":       cookie,
-		},
-	}, "POST")
+func makeImageRequest(ctx context.Context, jsonData []byte, account cookiepool.Account) (cycletls.Response, error) {
+	start := time.Now()
+	response, err := genspark.Ask(ctx, account, jsonData, "*/*")
+	reportUpstream(account, response.Status, start, err)
+	if err == nil {
+		refreshCookie(account, response)
+	}
+	return response, err
 }
 
-func makeDeleteRequest(client cycletls.CycleTLS, cookie, projectId string) (cycletls.Response, error) {
-	accept := "application/json"
-
-	return client.Do(fmt.Sprintf(deleteEndpoint, projectId), cycletls.Options{
-		Timeout: 10 * 60 * 60,
-		Method:  "GET",
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-			"Accept":       accept,
-			"Origin":       baseURL,
-			"Referer":      baseURL + "/",
-			"Cookie":       cookie,
-		},
-	}, "GET")
+func makeDeleteRequest(ctx context.Context, account cookiepool.Account, projectId string) (cycletls.Response, error) {
+	response, err := genspark.DeleteProject(ctx, account, projectId)
+	if err == nil {
+		refreshCookie(account, response)
+	}
+	return response, err
 }
 
-func makeGetUploadUrlRequest(client cycletls.CycleTLS, cookie string) (cycletls.Response, error) {
-
-	accept := "*/*"
+func makeGetUploadUrlRequest(ctx context.Context, account cookiepool.Account) (cycletls.Response, error) {
+	start := time.Now()
+	response, err := genspark.GetUploadURL(ctx, account)
+	reportUpstream(account, response.Status, start, err)
+	if err == nil {
+		refreshCookie(account, response)
+	}
+	return response, err
+}
 
-	return client.Do(fmt.Sprintf(uploadEndpoint), cycletls.Options{
-		Timeout: 10 * 60 * 60,
-		Method:  "GET",
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-			"Accept":       accept,
-			"Origin":       baseURL,
-			"Referer":      baseURL + "/",
-			"Cookie":       cookie,
-		},
-	}, "GET")
-}
-
-func makeOptionsRequest(client cycletls.CycleTLS, uploadUrl string) (cycletls.Response, error) {
-	return client.Do(uploadUrl, cycletls.Options{
-		Method: "OPTIONS",
-		Headers: map[string]string{
-			"Accept":                         "*/*",
-			"Access-Control-Request-Headers": "x-ms-blob-type",
-			"Access-Control-Request-Method":  "PUT",
-			"Origin":                         "https://www.genspark.ai",
-			"Sec-Fetch-Dest":                 "empty",
-			"Sec-Fetch-Mode":                 "cors",
-			"Sec-Fetch-Site":                 "cross-site",
-		},
-		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
-	}, "OPTIONS")
-}
-
-func makeUploadRequest(client cycletls.CycleTLS, uploadUrl string, fileBytes []byte) (cycletls.Response, error) {
-	return client.Do(uploadUrl, cycletls.Options{
-		Timeout: 30,
-		Method:  "PUT",
-		Body:    string(fileBytes),
-		Headers: map[string]string{
-			"Accept":         "*/*",
-			"x-ms-blob-type": "BlockBlob",
-			"Content-Type":   "application/octet-stream",
-			"Content-Length": fmt.Sprintf("%d", len(fileBytes)),
-			"Origin":         "https://www.genspark.ai",
-			"Sec-Fetch-Dest": "empty",
-			"Sec-Fetch-Mode": "cors",
-			"Sec-Fetch-Site": "cross-site",
-		},
-		Ja3:       "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513,29-23-24,0",
-		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
-	}, "PUT")
+func makeUploadRequest(ctx context.Context, account cookiepool.Account, uploadUrl string, fileBytes []byte) (cycletls.Response, error) {
+	return genspark.Upload(ctx, account, uploadUrl, fileBytes)
 }
 
 // ChatForOpenAI 处理OpenAI聊天请求
@@ -458,30 +656,45 @@ func ChatForOpenAI(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	cookie, err := common.RandomElement(config.GSCookies)
+	candidates, err := cookieCandidates(openAIReq.Messages, fileOwner(c))
 	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	account, release, err := acquireCookie(c, candidates, stickyKey(c, openAIReq.User))
+	if err != nil {
+		c.JSON(poolErrorStatus(c, err), gin.H{"error": err.Error()})
 		return
 	}
+	defer release()
 
-	requestBody := createRequestBody(c, cookie, &openAIReq)
+	// 生图模型走 COPILOT_MOA_IMAGE 流程
+	if lo.Contains(common.ImageModelList, openAIReq.Model) {
+		handleImageChatRequest(c, account, &openAIReq)
+		return
+	}
+
+	requestBody, promptTokens, err := createRequestBody(c, account, &openAIReq)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to marshal request body"})
 		return
 	}
 
-	client := cycletls.Init()
-
 	if openAIReq.Stream {
-		handleStreamRequest(c, client, cookie, jsonData, openAIReq.Model)
+		handleStreamRequest(c, account, jsonData, openAIReq.Model, promptTokens)
 	} else {
-		handleNonStreamRequest(c, client, cookie, jsonData, openAIReq.Model)
+		handleNonStreamRequest(c, account, jsonData, openAIReq.Model, promptTokens)
 	}
 
 }
 
 // handleStreamRequest 处理流式请求
-func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string, jsonData []byte, model string) {
+func handleStreamRequest(c *gin.Context, account cookiepool.Account, jsonData []byte, model string, promptTokens int) {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
@@ -489,39 +702,28 @@ func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string
 	responseId := fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405"))
 
 	c.Stream(func(w io.Writer) bool {
-		sseChan, err := makeStreamRequest(client, jsonData, cookie)
+		sseChan, err := makeStreamRequest(c.Request.Context(), jsonData, account)
 		if err != nil {
 			return false
 		}
 
-		return handleStreamResponse(c, sseChan, responseId, cookie, model)
+		return handleStreamResponse(c, sseChan, responseId, account, model, promptTokens)
 	})
 }
 
-func makeStreamRequest(client cycletls.CycleTLS, jsonData []byte, cookie string) (<-chan cycletls.SSEResponse, error) {
-	options := cycletls.Options{
-		Timeout: 10 * 60 * 60,
-		Body:    string(jsonData),
-		Method:  "POST",
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-			"Accept":       "text/event-stream",
-			"Origin":       baseURL,
-			"Referer":      baseURL + "/",
-			"Cookie":       cookie,
-		},
-	}
-
-	sseChan, err := client.DoSSE(apiEndpoint, options, "POST")
+func makeStreamRequest(ctx context.Context, jsonData []byte, account cookiepool.Account) (<-chan cycletls.SSEResponse, error) {
+	start := time.Now()
+	sseChan, err := genspark.AskStream(ctx, account, jsonData)
 	if err != nil {
+		reportUpstream(account, 0, start, err)
 		return nil, err
 	}
 	return sseChan, nil
 }
 
 // handleNonStreamRequest 处理非流式请求
-func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string, jsonData []byte, modelName string) {
-	response, err := makeRequest(client, jsonData, cookie, false)
+func handleNonStreamRequest(c *gin.Context, account cookiepool.Account, jsonData []byte, modelName string, promptTokens int) {
+	response, err := makeRequest(c.Request.Context(), jsonData, account, false)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -556,6 +758,7 @@ func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie str
 	}
 
 	finishReason := "stop"
+	completionTokens := common.CountTokens(content)
 	// 创建并返回 OpenAIChatCompletionResponse 结构
 	resp := model.OpenAIChatCompletionResponse{
 		ID:      fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405")),
@@ -571,8 +774,14 @@ func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie str
 				FinishReason: &finishReason,
 			},
 		},
+		Usage: model.OpenAIUsage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
 	}
 
+	warnEstimatedUsage(c, resp.Usage)
 	c.JSON(200, resp)
 }
 
@@ -602,34 +811,39 @@ func ImagesForOpenAI(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	cookie, err := common.RandomElement(config.GSCookies)
+	account, release, err := acquireCookie(c, cookiepool.IDs, stickyKey(c, openAIReq.User))
 	if err != nil {
+		c.JSON(poolErrorStatus(c, err), gin.H{"error": err.Error()})
 		return
 	}
+	defer release()
 
-	requestBody := createImageRequestBody(c, cookie, &openAIReq)
+	requestBody := createImageRequestBody(c, account, &openAIReq)
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to marshal request body"})
 		return
 	}
 
-	client := cycletls.Init()
-
-	response, err := makeImageRequest(client, jsonData, cookie)
+	response, err := makeImageRequest(c.Request.Context(), jsonData, account)
 
 	if err != nil {
 		return
 	} else {
 		// 解析响应获取task_ids
 		taskIDs := extractTaskIDs(response.Body)
+		if recordImageQuota(account, response.Body, taskIDs) {
+			c.JSON(429, gin.H{"error": "Daily image generation limit reached"})
+			return
+		}
 		if len(taskIDs) == 0 {
 			c.JSON(500, gin.H{"error": "No task IDs found"})
 			return
 		}
 
 		// 获取所有图片URL
-		imageURLs := pollTaskStatus(c, client, taskIDs, cookie)
+		imageURLs := pollTaskStatus(c, taskIDs, account, nil)
+		imageURLs = localizeImageURLs(c, account, imageURLs)
 
 		// 创建响应对象
 		response := model.OpenAIImagesGenerationResponse{
@@ -692,25 +906,39 @@ func extractTaskIDs(responseBody string) []string {
 	return taskIDs
 }
 
-func pollTaskStatus(c *gin.Context, client cycletls.CycleTLS, taskIDs []string, cookie string) []string {
+// taskPollInterval 查询生图任务状态的间隔
+var taskPollInterval = time.Second
+
+// pollTaskStatus 轮询生图任务状态, onProgress 不为 nil 时在任务状态变化时回调.
+// 任务失败时跳过该任务, 所有任务共用 IMAGE_TASK_TIMEOUT 的等待时间, 超时或客户端断开时返回已完成任务的图片
+func pollTaskStatus(c *gin.Context, taskIDs []string, account cookiepool.Account, onProgress func(index int, status string)) []string {
 	var imageURLs []string
 
-	for _, taskID := range taskIDs {
-		for {
-			// 构建请求URL
-			url := fmt.Sprintf("https://www.genspark.ai/api/spark/image_generation_task_status?task_id=%s", taskID)
-
-			// 发送请求
-			response, err := client.Do(url, cycletls.Options{
-				Method: "GET",
-				Headers: map[string]string{
-					"Cookie": cookie,
-				},
-			}, "GET")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(config.ImageTaskTimeout)*time.Second)
+	defer cancel()
+	// wait 等待下一次查询, ctx 结束时返回 false
+	wait := func() bool {
+		select {
+		case <-time.After(taskPollInterval):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 
+	for i, taskID := range taskIDs {
+		lastStatus := ""
+	poll:
+		for {
+			// 查询任务状态
+			response, err := genspark.TaskStatus(ctx, account, taskID)
 			if err != nil {
+				if !wait() {
+					break poll
+				}
 				continue
 			}
+			refreshCookie(account, response)
 
 			var result struct {
 				Data struct {
@@ -718,21 +946,180 @@ func pollTaskStatus(c *gin.Context, client cycletls.CycleTLS, taskIDs []string,
 					Status               string   `json:"status"`
 				}
 			}
-
 			if err := json.Unmarshal([]byte(response.Body), &result); err != nil {
+				if !wait() {
+					break poll
+				}
 				continue
 			}
 
-			// 如果状态成功且有图片URL
-			if result.Data.Status == "SUCCESS" && len(result.Data.ImageURLsNowatermark) > 0 {
-				imageURLs = append(imageURLs, result.Data.ImageURLsNowatermark...)
-				break
+			if onProgress != nil && result.Data.Status != lastStatus {
+				lastStatus = result.Data.Status
+				onProgress(i, lastStatus)
 			}
 
-			// 等待1秒后重试
-			time.Sleep(time.Second)
+			switch result.Data.Status {
+			case "SUCCESS":
+				// 状态成功且有图片URL
+				if len(result.Data.ImageURLsNowatermark) > 0 {
+					imageURLs = append(imageURLs, result.Data.ImageURLsNowatermark...)
+					break poll
+				}
+			case "FAILED", "ERROR":
+				logger.Warnf(c.Request.Context(), "image task %s %s", taskID, result.Data.Status)
+				break poll
+			}
+
+			if !wait() {
+				break poll
+			}
+		}
+		if ctx.Err() != nil {
+			if c.Request.Context().Err() == nil {
+				logger.Warnf(c.Request.Context(), "image tasks not finished within %ds", config.ImageTaskTimeout)
+			}
+			break
 		}
 	}
 
 	return imageURLs
 }
+
+// extractImagePrompt 取最后一条用户消息的文本作为生图提示词
+func extractImagePrompt(messages []model.OpenAIChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "user" {
+			continue
+		}
+		switch content := messages[i].Content.(type) {
+		case string:
+			return strings.TrimSpace(content)
+		case []interface{}:
+			var texts []string
+			for _, part := range content {
+				if partMap, ok := part.(map[string]interface{}); ok && partMap["type"] == "text" {
+					if text, ok := partMap["text"].(string); ok {
+						texts = append(texts, text)
+					}
+				}
+			}
+			return strings.TrimSpace(strings.Join(texts, "\n"))
+		}
+	}
+	return ""
+}
+
+// buildImageMarkdown 将图片URL组装为 markdown 图片链接
+func buildImageMarkdown(imageURLs []string) string {
+	var builder strings.Builder
+	for i, url := range imageURLs {
+		builder.WriteString(fmt.Sprintf("![image%d](%s)\n", i+1, url))
+	}
+	return builder.String()
+}
+
+// handleImageChatRequest 通过聊天接口生成图片
+func handleImageChatRequest(c *gin.Context, account cookiepool.Account, openAIReq *model.OpenAIChatCompletionRequest) {
+	prompt := extractImagePrompt(openAIReq.Messages)
+	if prompt == "" {
+		c.JSON(400, gin.H{"error": "No prompt found in user messages"})
+		return
+	}
+
+	modelName := openAIReq.Model
+	imageReq := &model.OpenAIImagesGenerationRequest{
+		Model:  openAIReq.Model,
+		Prompt: prompt,
+	}
+
+	requestBody := createImageRequestBody(c, account, imageReq)
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to marshal request body"})
+		return
+	}
+
+	responseId := fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405"))
+
+	if !openAIReq.Stream {
+		response, err := makeImageRequest(c.Request.Context(), jsonData, account)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		taskIDs := extractTaskIDs(response.Body)
+		if recordImageQuota(account, response.Body, taskIDs) {
+			c.JSON(429, gin.H{"error": "Daily image generation limit reached"})
+			return
+		}
+		if len(taskIDs) == 0 {
+			c.JSON(500, gin.H{"error": "No task IDs found"})
+			return
+		}
+		imageURLs := pollTaskStatus(c, taskIDs, account, nil)
+		imageURLs = localizeImageURLs(c, account, imageURLs)
+		if len(imageURLs) == 0 {
+			c.JSON(500, gin.H{"error": "No images generated"})
+			return
+		}
+
+		finishReason := "stop"
+		c.JSON(200, model.OpenAIChatCompletionResponse{
+			ID:      responseId,
+			Object:  "chat.completion",
+			Created: time.Now().Unix(),
+			Model:   modelName,
+			Choices: []model.OpenAIChoice{
+				{
+					Message: model.OpenAIMessage{
+						Role:    "assistant",
+						Content: buildImageMarkdown(imageURLs),
+					},
+					FinishReason: &finishReason,
+				},
+			},
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	sendDelta := func(content string) error {
+		return sendSSEvent(c, createStreamResponse(responseId, modelName, model.OpenAIDelta{Content: content, Role: "assistant"}, nil))
+	}
+
+	if err := sendDelta("> 正在提交生图任务...\n\n"); err != nil {
+		return
+	}
+
+	response, err := makeImageRequest(c.Request.Context(), jsonData, account)
+	if err != nil {
+		sendDelta(fmt.Sprintf("生图请求失败: %v\n", err))
+		handleMessageResult(c, responseId, modelName, nil)
+		return
+	}
+	taskIDs := extractTaskIDs(response.Body)
+	if recordImageQuota(account, response.Body, taskIDs) {
+		sendDelta("生图失败: Daily image generation limit reached\n")
+		handleMessageResult(c, responseId, modelName, nil)
+		return
+	}
+	if len(taskIDs) == 0 {
+		sendDelta("生图失败: No task IDs found\n")
+		handleMessageResult(c, responseId, modelName, nil)
+		return
+	}
+
+	imageURLs := pollTaskStatus(c, taskIDs, account, func(index int, status string) {
+		sendDelta(fmt.Sprintf("> 任务 %d/%d 状态: %s\n\n", index+1, len(taskIDs), status))
+	})
+	imageURLs = localizeImageURLs(c, account, imageURLs)
+	if len(imageURLs) == 0 {
+		sendDelta("生图失败: No images generated\n")
+	} else {
+		sendDelta(buildImageMarkdown(imageURLs))
+	}
+	handleMessageResult(c, responseId, modelName, nil)
+}
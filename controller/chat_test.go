@@ -0,0 +1,387 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"genspark2api/common/config"
+	"genspark2api/common/cookiepool"
+	"genspark2api/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deanxv/CycleTLS/cycletls"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeGenspark 记录请求并返回预设响应的上游实现
+type fakeGenspark struct {
+	mutex   sync.Mutex
+	asks    []fakeAsk
+	answer  string
+	events  []string
+	askErr  error
+	deleted []string
+	// proxyStatus CheckProxy 返回的状态码, proxies 记录检查过的代理
+	proxyStatus int
+	proxies     []string
+	// tasks 各生图任务依次返回的响应体, 用完后重复最后一个
+	tasks map[string][]string
+	// uploads 上传到私有存储的文件内容
+	uploads [][]byte
+}
+
+type fakeAsk struct {
+	cookie string
+	body   map[string]interface{}
+	accept string
+}
+
+func (f *fakeGenspark) record(account cookiepool.Account, body []byte, accept string) {
+	var parsed map[string]interface{}
+	json.Unmarshal(body, &parsed)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.asks = append(f.asks, fakeAsk{cookie: account.Cookie(), body: parsed, accept: accept})
+}
+
+func (f *fakeGenspark) Ask(ctx context.Context, account cookiepool.Account, body []byte, accept string) (cycletls.Response, error) {
+	f.record(account, body, accept)
+	if f.askErr != nil {
+		return cycletls.Response{}, f.askErr
+	}
+	return cycletls.Response{Status: http.StatusOK, Body: f.answer}, nil
+}
+
+func (f *fakeGenspark) AskStream(ctx context.Context, account cookiepool.Account, body []byte) (<-chan cycletls.SSEResponse, error) {
+	f.record(account, body, "text/event-stream")
+	if f.askErr != nil {
+		return nil, f.askErr
+	}
+	events := make(chan cycletls.SSEResponse, len(f.events)+1)
+	for _, event := range f.events {
+		events <- cycletls.SSEResponse{Status: http.StatusOK, Data: "data: " + event}
+	}
+	events <- cycletls.SSEResponse{Status: http.StatusOK, Done: true}
+	close(events)
+	return events, nil
+}
+
+func (f *fakeGenspark) DeleteProject(ctx context.Context, account cookiepool.Account, projectID string) (cycletls.Response, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.deleted = append(f.deleted, projectID)
+	return cycletls.Response{Status: http.StatusOK}, nil
+}
+
+func (f *fakeGenspark) GetUploadURL(ctx context.Context, account cookiepool.Account) (cycletls.Response, error) {
+	return cycletls.Response{
+		Status: http.StatusOK,
+		Body:   `{"data":{"upload_image_url":"https://blob.example/upload","private_storage_url":"https://blob.example/private"}}`,
+	}, nil
+}
+
+func (f *fakeGenspark) Upload(ctx context.Context, account cookiepool.Account, uploadURL string, data []byte) (cycletls.Response, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.uploads = append(f.uploads, data)
+	return cycletls.Response{Status: http.StatusCreated}, nil
+}
+
+func (f *fakeGenspark) TaskStatus(ctx context.Context, account cookiepool.Account, taskID string) (cycletls.Response, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	bodies := f.tasks[taskID]
+	if len(bodies) == 0 {
+		return cycletls.Response{}, errors.New("unknown task")
+	}
+	body := bodies[0]
+	if len(bodies) > 1 {
+		f.tasks[taskID] = bodies[1:]
+	}
+	return cycletls.Response{Status: http.StatusOK, Body: body}, nil
+}
+
+func (f *fakeGenspark) Download(ctx context.Context, account cookiepool.Account, url string) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeGenspark) CheckProxy(ctx context.Context, proxy string) (cycletls.Response, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.proxies = append(f.proxies, proxy)
+	return cycletls.Response{Status: f.proxyStatus}, nil
+}
+
+// useFakeGenspark 使用假的上游及只含一个 cookie 的内存 cookie 池, 测试结束后恢复
+func useFakeGenspark(t *testing.T, fake *fakeGenspark) {
+	gin.SetMode(gin.TestMode)
+	previous := genspark
+	SetGensparkClient(fake)
+	cookiepool.SetCookies([]string{"session_id=test"})
+	t.Cleanup(func() {
+		SetGensparkClient(previous)
+		cookiepool.SetCookies(nil)
+	})
+}
+
+// postChat 通过本地服务调用 ChatForOpenAI(流式响应需要支持 CloseNotify 的 ResponseWriter)
+func postChat(t *testing.T, request model.OpenAIChatCompletionRequest) (*http.Response, string) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.POST("/v1/chat/completions", ChatForOpenAI)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(content)
+}
+
+func chatRequest(stream bool) model.OpenAIChatCompletionRequest {
+	return model.OpenAIChatCompletionRequest{
+		Model:    "gpt-4o",
+		Stream:   stream,
+		Messages: []model.OpenAIChatMessage{{Role: "user", Content: "hello"}},
+	}
+}
+
+func TestChatForOpenAI(t *testing.T) {
+	fake := &fakeGenspark{
+		answer: "data: {\"type\":\"project_start\",\"id\":\"p1\"}\n" +
+			"data: {\"type\":\"message_field_delta\",\"field_name\":\"session_state.answer\",\"delta\":\"Hi\"}\n" +
+			"data: {\"type\":\"message_result\",\"content\":\"Hi there\"}\n",
+	}
+	useFakeGenspark(t, fake)
+
+	httpResp, body := postChat(t, chatRequest(false))
+	if httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", httpResp.StatusCode, body)
+	}
+	var resp model.OpenAIChatCompletionResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "Hi there" || resp.Model != "gpt-4o" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if resp.Usage.PromptTokens == 0 || resp.Usage.CompletionTokens == 0 {
+		t.Fatalf("missing usage %+v", resp.Usage)
+	}
+
+	if len(fake.asks) != 1 {
+		t.Fatalf("%d upstream requests, want 1", len(fake.asks))
+	}
+	ask := fake.asks[0]
+	if ask.cookie != "session_id=test" || ask.accept != "application/json" {
+		t.Fatalf("upstream request used cookie %q accept %q", ask.cookie, ask.accept)
+	}
+	models, _ := ask.body["extra_data"].(map[string]interface{})["models"].([]interface{})
+	if len(models) != 1 || models[0] != "gpt-4o" {
+		t.Fatalf("upstream request models = %v", models)
+	}
+}
+
+func TestChatForOpenAIStream(t *testing.T) {
+	fake := &fakeGenspark{
+		events: []string{
+			`{"type":"project_start","id":"p1"}`,
+			`{"type":"message_field_delta","field_name":"session_state.answer","delta":"Hi"}`,
+			`{"type":"message_field_delta","field_name":"session_state.answer","delta":" there"}`,
+			`{"type":"message_result","content":"Hi there"}`,
+		},
+	}
+	useFakeGenspark(t, fake)
+
+	httpResp, body := postChat(t, chatRequest(true))
+	if httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", httpResp.StatusCode, body)
+	}
+	if contentType := httpResp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		t.Fatalf("Content-Type = %q", contentType)
+	}
+
+	var content strings.Builder
+	var finished, done bool
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk model.OpenAIChatCompletionResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		content.WriteString(chunk.Choices[0].Delta.Content)
+		if reason := chunk.Choices[0].FinishReason; reason != nil && *reason == "stop" {
+			finished = true
+			if chunk.Usage.CompletionTokens == 0 {
+				t.Fatalf("last chunk missing usage: %+v", chunk.Usage)
+			}
+		}
+	}
+	if content.String() != "Hi there" || !finished || !done {
+		t.Fatalf("stream content %q finished=%v done=%v:\n%s", content.String(), finished, done, body)
+	}
+	if len(fake.asks) != 1 || fake.asks[0].accept != "text/event-stream" {
+		t.Fatalf("upstream requests %+v", fake.asks)
+	}
+}
+
+func TestChatForOpenAIErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fake    *fakeGenspark
+		cookies []string
+		status  int
+	}{
+		{"upstream error", &fakeGenspark{askErr: errors.New("connection reset")}, []string{"session_id=test"}, http.StatusInternalServerError},
+		{"no content", &fakeGenspark{answer: "data: {\"type\":\"project_start\"}\n"}, []string{"session_id=test"}, http.StatusInternalServerError},
+		{"empty pool", &fakeGenspark{}, nil, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeGenspark(t, tt.fake)
+			cookiepool.SetCookies(tt.cookies)
+
+			httpResp, body := postChat(t, chatRequest(false))
+			if httpResp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d: %s", httpResp.StatusCode, tt.status, body)
+			}
+			var resp struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(body), &resp); err != nil || resp.Error == "" {
+				t.Fatalf("missing error message: %s", body)
+			}
+		})
+	}
+}
+
+func taskBody(status string, urls ...string) string {
+	body, _ := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{"status": status, "image_urls_nowatermark": urls},
+	})
+	return string(body)
+}
+
+func TestPollTaskStatus(t *testing.T) {
+	interval, timeout := taskPollInterval, config.ImageTaskTimeout
+	taskPollInterval, config.ImageTaskTimeout = time.Millisecond, 1
+	defer func() { taskPollInterval, config.ImageTaskTimeout = interval, timeout }()
+
+	tests := []struct {
+		name     string
+		tasks    map[string][]string
+		want     []string
+		statuses []string
+		// minElapsed 为等待超时的用例设置
+		minElapsed time.Duration
+	}{
+		{
+			name:     "success",
+			tasks:    map[string][]string{"t1": {taskBody("PENDING"), taskBody("PENDING"), taskBody("SUCCESS", "https://img/1")}},
+			want:     []string{"https://img/1"},
+			statuses: []string{"PENDING", "SUCCESS"},
+		},
+		{
+			name: "failed task skipped",
+			tasks: map[string][]string{
+				"t1": {taskBody("PENDING"), taskBody("FAILED")},
+				"t2": {taskBody("SUCCESS", "https://img/2")},
+			},
+			want:     []string{"https://img/2"},
+			statuses: []string{"PENDING", "FAILED", "SUCCESS"},
+		},
+		{
+			name:     "error",
+			tasks:    map[string][]string{"t1": {taskBody("ERROR")}},
+			statuses: []string{"ERROR"},
+		},
+		{
+			name:     "invalid response retried",
+			tasks:    map[string][]string{"t1": {"<html>", "<html>", taskBody("SUCCESS", "https://img/1")}},
+			want:     []string{"https://img/1"},
+			statuses: []string{"SUCCESS"},
+		},
+		{
+			name:       "timeout",
+			tasks:      map[string][]string{"t1": {taskBody("PENDING")}, "t2": {taskBody("SUCCESS", "https://img/2")}},
+			statuses:   []string{"PENDING"},
+			minElapsed: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeGenspark{tasks: tt.tasks}
+			useFakeGenspark(t, fake)
+			account := cookiepool.AccountFor(cookiepool.IDs()[0])
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+
+			taskIDs := []string{"t1"}
+			if _, ok := tt.tasks["t2"]; ok {
+				taskIDs = append(taskIDs, "t2")
+			}
+			var statuses []string
+			start := time.Now()
+			got := pollTaskStatus(c, taskIDs, account, func(index int, status string) {
+				statuses = append(statuses, status)
+			})
+			elapsed := time.Since(start)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("image URLs %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(statuses, tt.statuses) {
+				t.Fatalf("statuses %v, want %v", statuses, tt.statuses)
+			}
+			if elapsed < tt.minElapsed || elapsed > tt.minElapsed+time.Second {
+				t.Fatalf("returned after %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestPollTaskStatusClientGone(t *testing.T) {
+	interval := taskPollInterval
+	taskPollInterval = time.Millisecond
+	defer func() { taskPollInterval = interval }()
+
+	fake := &fakeGenspark{tasks: map[string][]string{"t1": {taskBody("PENDING")}}}
+	useFakeGenspark(t, fake)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil).WithContext(ctx)
+
+	start := time.Now()
+	if got := pollTaskStatus(c, []string{"t1"}, cookiepool.AccountFor(cookiepool.IDs()[0]), nil); len(got) != 0 {
+		t.Fatalf("image URLs %v", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("kept polling for %s after the client disconnected", elapsed)
+	}
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/common/cookiepool"
+	logger "genspark2api/common/loggger"
+	"genspark2api/common/storage"
+	"genspark2api/common/uploadcache"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// ServeFile 提供本地存储文件的访问, 需携带有效签名
+func ServeFile(c *gin.Context) {
+	name := c.Param("name")
+	if !storage.Verify(name, c.Query("expires"), c.Query("sig")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired signature"})
+		return
+	}
+	path, err := storage.Path(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	}
+	c.File(path)
+}
+
+// fileBaseURL 返回生成文件链接使用的外部访问地址.
+// 未配置 FILE_BASE_URL 时使用请求的 Host, 只有来自 TRUSTED_PROXIES 的请求才使用 X-Forwarded-Proto/X-Forwarded-Host
+func fileBaseURL(c *gin.Context) string {
+	if config.FileBaseURL != "" {
+		return config.FileBaseURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if fromTrustedProxy(c) {
+		if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+			host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
+
+// fromTrustedProxy 判断请求是否直接来自 TRUSTED_PROXIES 中的地址
+func fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range config.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if trusted := net.ParseIP(proxy); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveImage 通过账号使用的代理下载生成的图片并保存到本地存储
+func saveImage(ctx context.Context, account cookiepool.Account, url string) (string, error) {
+	data, err := genspark.Download(ctx, account, url)
+	if err != nil {
+		return "", err
+	}
+	ext, ok := imageExtensions[http.DetectContentType(data)]
+	if !ok {
+		ext = ".png"
+	}
+	return storage.Save(data, ext)
+}
+
+// localizeImageURLs 开启本地存储时将生成的图片转存并替换为签名链接, 失败时保留原链接
+func localizeImageURLs(c *gin.Context, account cookiepool.Account, imageURLs []string) []string {
+	if !config.LocalStorageEnable {
+		return imageURLs
+	}
+	baseURL := fileBaseURL(c)
+	result := make([]string, 0, len(imageURLs))
+	for _, url := range imageURLs {
+		name, err := saveImage(c.Request.Context(), account, url)
+		if err != nil {
+			logger.Errorf(c.Request.Context(), "保存图片失败 %s: %v", url, err)
+			result = append(result, url)
+			continue
+		}
+		result = append(result, storage.SignedURL(baseURL, name))
+	}
+	return result
+}
+
+// UploadCacheStats 查看附件上传缓存的命中统计
+func UploadCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    uploadcache.GetStats(),
+	})
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"genspark2api/common/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFileBaseURL(t *testing.T) {
+	previous, previousProxies := config.FileBaseURL, config.TrustedProxies
+	defer func() { config.FileBaseURL, config.TrustedProxies = previous, previousProxies }()
+	config.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+
+	forwarded := map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "files.example.com, proxy.internal"}
+	tests := []struct {
+		name       string
+		baseURL    string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"configured", "https://cdn.example.com", "10.0.0.1:1234", forwarded, "https://cdn.example.com"},
+		{"direct", "", "203.0.113.1:1234", nil, "http://api.example.com"},
+		// 不信任客户端直接发送的转发头
+		{"untrusted forwarded", "", "203.0.113.1:1234", forwarded, "http://api.example.com"},
+		{"trusted cidr", "", "10.1.2.3:1234", forwarded, "https://files.example.com"},
+		{"trusted ip", "", "192.168.1.1:1234", forwarded, "https://files.example.com"},
+		{"invalid proto", "", "10.1.2.3:1234", map[string]string{"X-Forwarded-Proto": "javascript"}, "http://api.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.FileBaseURL = tt.baseURL
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "http://api.example.com/v1/images/generations", nil)
+			c.Request.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				c.Request.Header.Set(key, value)
+			}
+			if got := fileBaseURL(c); got != tt.want {
+				t.Fatalf("fileBaseURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
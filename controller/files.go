@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"genspark2api/common"
+	"genspark2api/common/config"
+	"genspark2api/common/cookiepool"
+	"genspark2api/common/filestore"
+	"genspark2api/model"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const fileIDPrefix = "file-"
+
+func toOpenAIFile(file *filestore.File) model.OpenAIFile {
+	return model.OpenAIFile{
+		ID:        file.ID,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    "processed",
+	}
+}
+
+func fileErrorResponse(c *gin.Context, code int, message string) {
+	c.JSON(code, model.OpenAIErrorResponse{
+		OpenAIError: model.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+		},
+	})
+}
+
+// fileOwner 返回调用方 API key 的摘要, 文件记录中不保存明文; 未配置 API_SECRET 时所有调用方共享文件
+func fileOwner(c *gin.Context) string {
+	apiKey := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ownedFile 按ID获取调用方上传的文件记录, 其他 API key 上传的文件视为不存在
+func ownedFile(id string, owner string) (*filestore.File, bool) {
+	file, ok := filestore.Get(id)
+	if !ok || file.Owner != owner {
+		return nil, false
+	}
+	return file, true
+}
+
+// UploadFile 上传文件到 Genspark 私有存储并返回可复用的 file_id, 文件大小不超过 FETCH_MAX_BYTES
+func UploadFile(c *gin.Context) {
+	maxBytes := int64(config.FetchMaxBytes)
+	// 为 multipart 的边界及其他字段预留 1MB
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1<<20)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			fileErrorResponse(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds the %d byte limit", maxBytes))
+			return
+		}
+		fileErrorResponse(c, http.StatusBadRequest, "missing file field")
+		return
+	}
+	if fileHeader.Size > maxBytes {
+		fileErrorResponse(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds the %d byte limit", maxBytes))
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		purpose = "user_data"
+	}
+
+	f, err := fileHeader.Open()
+	if err != nil {
+		fileErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer f.Close()
+	bytes, err := io.ReadAll(io.LimitReader(f, maxBytes+1))
+	if err != nil {
+		fileErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if int64(len(bytes)) > maxBytes {
+		fileErrorResponse(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds the %d byte limit", maxBytes))
+		return
+	}
+
+	account, release, err := acquireCookie(c, cookiepool.IDs, stickyKey(c, ""))
+	if err != nil {
+		fileErrorResponse(c, poolErrorStatus(c, err), err.Error())
+		return
+	}
+	defer release()
+
+	contentType, ext := common.DetectFileType(bytes, fileHeader.Filename, fileHeader.Header.Get("Content-Type"))
+	filename := common.FileNameWithExt(fileHeader.Filename, ext)
+	privateFile, err := uploadPrivateFile(c.Request.Context(), account, bytes, filename, contentType, ext)
+	if err != nil {
+		fileErrorResponse(c, http.StatusBadGateway, err.Error())
+		return
+	}
+	privateStorageUrl, _ := privateFile["private_file"].(map[string]interface{})["private_storage_url"].(string)
+
+	file := &filestore.File{
+		ID:                fileIDPrefix + common.GetUUID()[:24],
+		Filename:          filename,
+		Purpose:           purpose,
+		Bytes:             len(bytes),
+		MimeType:          contentType,
+		Ext:               ext,
+		CreatedAt:         time.Now().Unix(),
+		PrivateStorageURL: privateStorageUrl,
+		CookieID:          account.ID,
+		Owner:             fileOwner(c),
+	}
+	if err := filestore.Add(file); err != nil {
+		fileErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, toOpenAIFile(file))
+}
+
+// ListFiles 列出调用方上传的文件
+func ListFiles(c *gin.Context) {
+	purpose := c.Query("purpose")
+	owner := fileOwner(c)
+	data := make([]model.OpenAIFile, 0)
+	for _, file := range filestore.List() {
+		if file.Owner != owner || (purpose != "" && file.Purpose != purpose) {
+			continue
+		}
+		data = append(data, toOpenAIFile(file))
+	}
+	c.JSON(http.StatusOK, model.OpenAIFileListResponse{
+		Object: "list",
+		Data:   data,
+	})
+}
+
+// RetrieveFile 获取文件信息
+func RetrieveFile(c *gin.Context) {
+	file, ok := ownedFile(c.Param("file_id"), fileOwner(c))
+	if !ok {
+		fileErrorResponse(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", c.Param("file_id")))
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIFile(file))
+}
+
+// DeleteFile 删除文件记录
+func DeleteFile(c *gin.Context) {
+	id := c.Param("file_id")
+	if _, ok := ownedFile(id, fileOwner(c)); !ok {
+		fileErrorResponse(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", id))
+		return
+	}
+	deleted, err := filestore.Delete(id)
+	if err != nil {
+		fileErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		fileErrorResponse(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", id))
+		return
+	}
+	c.JSON(http.StatusOK, model.OpenAIFileDeleteResponse{
+		ID:      id,
+		Object:  "file",
+		Deleted: true,
+	})
+}
+
+// privateFileFromStore 根据 file_id 构造 private_file 内容
+func privateFileFromStore(fileID string, account cookiepool.Account) (map[string]interface{}, error) {
+	file, ok := filestore.Get(fileID)
+	if !ok {
+		return nil, fmt.Errorf("file not found: %s", fileID)
+	}
+	if file.CookieID != account.ID {
+		return nil, fmt.Errorf("file %s was uploaded with a different cookie", fileID)
+	}
+	return map[string]interface{}{
+		"type": "private_file",
+		"private_file": map[string]interface{}{
+			"name":                file.Filename,
+			"type":                file.MimeType,
+			"size":                file.Bytes,
+			"ext":                 strings.TrimPrefix(file.Ext, "."),
+			"private_storage_url": file.PrivateStorageURL,
+		},
+	}, nil
+}
+
+// referencedFileIDs 收集消息中通过 file_id 引用的文件
+func referencedFileIDs(messages []model.OpenAIChatMessage) []string {
+	var ids []string
+	for _, message := range messages {
+		contentArray, ok := message.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, content := range contentArray {
+			contentMap, ok := content.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			fileMap := contentMap
+			if contentMap["type"] == "file" {
+				fileMap, _ = contentMap["file"].(map[string]interface{})
+			} else if contentMap["type"] != "input_file" {
+				continue
+			}
+			if id, ok := fileMap["file_id"].(string); ok && id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// stickyKey 返回粘性选择使用的标识, 优先使用请求中的 user 字段, 其次为 API key
+func stickyKey(c *gin.Context, user string) string {
+	if user != "" {
+		return "user:" + user
+	}
+	if apiKey := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); apiKey != "" {
+		return "key:" + apiKey
+	}
+	return ""
+}
+
+// cookieCandidates 返回本次请求可使用的 token ID, 引用了已上传文件时只能使用上传该文件的 token;
+// 只能引用 owner 上传的文件
+func cookieCandidates(messages []model.OpenAIChatMessage, owner string) (func() []string, error) {
+	boundCookieID := ""
+	for _, id := range referencedFileIDs(messages) {
+		file, ok := ownedFile(id, owner)
+		if !ok {
+			return nil, fmt.Errorf("file not found: %s", id)
+		}
+		if boundCookieID != "" && boundCookieID != file.CookieID {
+			return nil, fmt.Errorf("referenced files were uploaded with different cookies")
+		}
+		boundCookieID = file.CookieID
+	}
+
+	if boundCookieID == "" {
+		return cookiepool.IDs, nil
+	}
+	bound := func() []string {
+		for _, id := range cookiepool.IDs() {
+			if id == boundCookieID {
+				return []string{id}
+			}
+		}
+		return nil
+	}
+	if len(bound()) == 0 {
+		return nil, fmt.Errorf("the cookie that uploaded the referenced file is no longer available, please upload it again")
+	}
+	return bound, nil
+}
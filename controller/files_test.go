@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"genspark2api/common/config"
+	"genspark2api/model"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// filesServer 启动挂载 Files API 及对话接口的本地服务, 文件记录保存在临时目录
+func filesServer(t *testing.T) *httptest.Server {
+	path, maxBytes := config.FileStorePath, config.FetchMaxBytes
+	config.FileStorePath = filepath.Join(t.TempDir(), "files.json")
+	config.FetchMaxBytes = 1024
+	t.Cleanup(func() { config.FileStorePath, config.FetchMaxBytes = path, maxBytes })
+
+	router := gin.New()
+	router.POST("/v1/files", UploadFile)
+	router.GET("/v1/files", ListFiles)
+	router.GET("/v1/files/:file_id", RetrieveFile)
+	router.DELETE("/v1/files/:file_id", DeleteFile)
+	router.POST("/v1/chat/completions", ChatForOpenAI)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// call 以 apiKey 发送请求并返回状态码及响应体
+func call(t *testing.T, method, url, apiKey, contentType string, body io.Reader) (int, []byte) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, content
+}
+
+func uploadFile(t *testing.T, server *httptest.Server, apiKey string, content []byte) (int, model.OpenAIFile) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	status, resp := call(t, http.MethodPost, server.URL+"/v1/files", apiKey, writer.FormDataContentType(), &body)
+	var file model.OpenAIFile
+	if status == http.StatusOK {
+		if err := json.Unmarshal(resp, &file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return status, file
+}
+
+func TestUploadFileSizeLimit(t *testing.T) {
+	fake := &fakeGenspark{}
+	useFakeGenspark(t, fake)
+	server := filesServer(t)
+
+	tests := []struct {
+		name   string
+		size   int
+		status int
+	}{
+		{"within limit", 1024, http.StatusOK},
+		{"over limit", 1025, http.StatusRequestEntityTooLarge},
+		{"request body over limit", 4 << 20, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploads := len(fake.uploads)
+			status, file := uploadFile(t, server, "key-a", bytes.Repeat([]byte("a"), tt.size))
+			if status != tt.status {
+				t.Fatalf("status %d, want %d", status, tt.status)
+			}
+			// 超过限制的文件不会上传到上游
+			if want := uploads; tt.status == http.StatusOK {
+				if len(fake.uploads) != want+1 || file.Bytes != tt.size {
+					t.Fatalf("uploaded %d files, file %+v", len(fake.uploads)-want, file)
+				}
+			} else if len(fake.uploads) != want {
+				t.Fatalf("oversized file uploaded")
+			}
+		})
+	}
+}
+
+func TestFilesScopedToAPIKey(t *testing.T) {
+	useFakeGenspark(t, &fakeGenspark{answer: "data: {\"type\":\"message_result\",\"content\":\"ok\"}\n"})
+	server := filesServer(t)
+	// 文件记录在进程内共享, 使用其他测试未用过的 API key
+
+	status, file := uploadFile(t, server, "owner", []byte("hello"))
+	if status != http.StatusOK {
+		t.Fatalf("upload status %d", status)
+	}
+	fileURL := server.URL + "/v1/files/" + file.ID
+
+	listed := func(apiKey string) []model.OpenAIFile {
+		status, body := call(t, http.MethodGet, server.URL+"/v1/files", apiKey, "", nil)
+		if status != http.StatusOK {
+			t.Fatalf("list status %d", status)
+		}
+		var list model.OpenAIFileListResponse
+		if err := json.Unmarshal(body, &list); err != nil {
+			t.Fatal(err)
+		}
+		return list.Data
+	}
+	chatWithFile := func(apiKey string) int {
+		body, _ := json.Marshal(map[string]interface{}{
+			"model": "gpt-4o",
+			"messages": []map[string]interface{}{{
+				"role":    "user",
+				"content": []map[string]interface{}{{"type": "file", "file": map[string]interface{}{"file_id": file.ID}}},
+			}},
+		})
+		status, _ := call(t, http.MethodPost, server.URL+"/v1/chat/completions", apiKey, "application/json", bytes.NewReader(body))
+		return status
+	}
+
+	// 其他 API key 看不到、不能读取、删除或引用该文件
+	if files := listed("other"); len(files) != 0 {
+		t.Fatalf("other listed %+v", files)
+	}
+	if status, _ := call(t, http.MethodGet, fileURL, "other", "", nil); status != http.StatusNotFound {
+		t.Fatalf("other retrieve status %d", status)
+	}
+	if status, _ := call(t, http.MethodDelete, fileURL, "other", "", nil); status != http.StatusNotFound {
+		t.Fatalf("other delete status %d", status)
+	}
+	if status := chatWithFile("other"); status != http.StatusBadRequest {
+		t.Fatalf("other chat status %d", status)
+	}
+
+	// 上传的 API key 可以正常使用
+	if files := listed("owner"); len(files) != 1 || files[0].ID != file.ID {
+		t.Fatalf("owner listed %+v", files)
+	}
+	if status, _ := call(t, http.MethodGet, fileURL, "owner", "", nil); status != http.StatusOK {
+		t.Fatalf("owner retrieve status %d", status)
+	}
+	if status := chatWithFile("owner"); status != http.StatusOK {
+		t.Fatalf("owner chat status %d", status)
+	}
+	if status, _ := call(t, http.MethodDelete, fileURL, "owner", "", nil); status != http.StatusOK {
+		t.Fatalf("owner delete status %d", status)
+	}
+	if status, _ := call(t, http.MethodGet, fileURL, "owner", "", nil); status != http.StatusNotFound {
+		t.Fatalf("retrieve after delete status %d", status)
+	}
+}
@@ -0,0 +1,286 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/common/cookiepool"
+	"genspark2api/common/fingerprint"
+	logger "genspark2api/common/loggger"
+	"genspark2api/common/upstream"
+	"github.com/deanxv/CycleTLS/cycletls"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// classifyStatus 根据上游状态码判断失败类型, 成功时返回 false
+func classifyStatus(status int) (cookiepool.FailureKind, bool) {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return cookiepool.FailureAuth, true
+	case status == http.StatusTooManyRequests:
+		return cookiepool.FailureRateLimit, true
+	case status == 0 || status >= 500:
+		return cookiepool.FailureOther, true
+	}
+	return "", false
+}
+
+// reportUpstream 根据上游请求结果更新账号及代理的健康状态
+func reportUpstream(account cookiepool.Account, status int, start time.Time, err error) {
+	if errors.Is(err, context.Canceled) {
+		// 客户端断开导致的取消与上游状态无关
+		return
+	}
+	latency := time.Since(start)
+	proxy := account.Proxy()
+	if err != nil {
+		// 连接层错误(含代理连接失败)与 cookie 无关, 使用代理时计入代理的健康状态
+		if proxy != "" {
+			cookiepool.ReportProxyFailure(proxy, err.Error())
+			cookiepool.ReportFailure(account.ID, cookiepool.FailureProxy, latency, err.Error())
+			return
+		}
+		cookiepool.ReportFailure(account.ID, cookiepool.FailureOther, latency, err.Error())
+		return
+	}
+	cookiepool.ReportProxySuccess(proxy, latency)
+	if kind, failed := classifyStatus(status); failed {
+		cookiepool.ReportFailure(account.ID, kind, latency, fmt.Sprintf("status %d", status))
+		return
+	}
+	cookiepool.ReportSuccess(account.ID, latency)
+}
+
+// requestPriority 从 X-Priority 请求头读取排队优先级, 数值越大越优先, 默认为0
+func requestPriority(c *gin.Context) int {
+	priority, _ := strconv.Atoi(c.GetHeader("X-Priority"))
+	return priority
+}
+
+// acquireCookie 为请求占用一个账号, 客户端断开时停止排队
+func acquireCookie(c *gin.Context, candidates func() []string, key string) (cookiepool.Account, func(), error) {
+	return cookiepool.AcquireCookie(c.Request.Context(), candidates, key, requestPriority(c))
+}
+
+// poolErrorStatus 返回获取 cookie 失败时的状态码, 503 时设置 Retry-After
+func poolErrorStatus(c *gin.Context, err error) int {
+	switch {
+	case errors.Is(err, cookiepool.ErrQueueFull), errors.Is(err, cookiepool.ErrQueueTimeout):
+		retryAfter := int(math.Ceil(float64(cookiepool.GetQueueStats().AvgWaitMs) / 1000))
+		c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+		return http.StatusServiceUnavailable
+	case errors.Is(err, cookiepool.ErrNoAvailableCookie):
+		c.Header("Retry-After", strconv.Itoa(max(config.CookieCooldownSeconds, 1)))
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// responseCookies 返回上游响应中的 Set-Cookie, cycletls 未解析时从以 "/,/" 拼接的响应头中解析
+func responseCookies(response cycletls.Response) []*http.Cookie {
+	if len(response.Cookies) > 0 {
+		return response.Cookies
+	}
+	header := response.Headers["Set-Cookie"]
+	if header == "" {
+		return nil
+	}
+	return (&http.Response{Header: http.Header{"Set-Cookie": strings.Split(header, "/,/")}}).Cookies()
+}
+
+// isGensparkCookie 只合并属于 genspark 域名的 cookie
+func isGensparkCookie(cookie *http.Cookie) bool {
+	domain := strings.TrimPrefix(strings.ToLower(cookie.Domain), ".")
+	return domain == "" || domain == "genspark.ai" || strings.HasSuffix(domain, ".genspark.ai")
+}
+
+// refreshCookie 将上游响应的 Set-Cookie 合并进账号的 cookie 并持久化, 保持会话长期有效
+func refreshCookie(account cookiepool.Account, response cycletls.Response) {
+	if !config.CookieAutoRefresh {
+		return
+	}
+	var setCookies []*http.Cookie
+	for _, setCookie := range responseCookies(response) {
+		if isGensparkCookie(setCookie) {
+			setCookies = append(setCookies, setCookie)
+		}
+	}
+	if len(setCookies) == 0 {
+		return
+	}
+	changed, err := cookiepool.RefreshCookie(account.ID, setCookies)
+	if err != nil {
+		// token 已被删除
+		if !errors.Is(err, cookiepool.ErrTokenNotFound) {
+			logger.SysError("刷新 cookie 失败: " + err.Error())
+		}
+		return
+	}
+	if changed {
+		logger.SysLog("token " + account.ID + " 的 cookie 已根据 Set-Cookie 刷新")
+	}
+}
+
+// checkCookie 通过获取上传地址接口校验账号的 cookie, 该接口需要登录且不消耗额度
+func checkCookie(account cookiepool.Account) (cookiepool.CheckStatus, string) {
+	response, err := genspark.GetUploadURL(context.Background(), account)
+	if err != nil {
+		// 连接失败时无法判断 cookie 状态, 由代理健康检查负责处理
+		if proxy := account.Proxy(); proxy != "" {
+			cookiepool.ReportProxyFailure(proxy, err.Error())
+		}
+		return cookiepool.CheckError, "network error: " + err.Error()
+	}
+	refreshCookie(account, response)
+	return classifyCheck(response.Status, response.Body)
+}
+
+// checkProxy 通过代理访问上游首页, 检查代理是否可用
+func checkProxy(proxy string) error {
+	response, err := genspark.CheckProxy(context.Background(), proxy)
+	if err != nil {
+		return err
+	}
+	if response.Status == http.StatusProxyAuthRequired {
+		return errors.New("status 407")
+	}
+	return nil
+}
+
+// classifyCheck 根据校验请求的响应判断 cookie 状态
+func classifyCheck(status int, body string) (cookiepool.CheckStatus, string) {
+	lowerBody := strings.ToLower(body)
+	if strings.Contains(lowerBody, "banned") || strings.Contains(lowerBody, "suspended") {
+		return cookiepool.CheckBanned, fmt.Sprintf("status %d: account banned", status)
+	}
+	switch {
+	case status == http.StatusUnauthorized:
+		return cookiepool.CheckExpired, "status 401"
+	case status == http.StatusForbidden:
+		return cookiepool.CheckBanned, "status 403"
+	case status == http.StatusTooManyRequests:
+		return cookiepool.CheckRateLimited, "status 429"
+	case status != http.StatusOK:
+		return cookiepool.CheckError, fmt.Sprintf("status %d", status)
+	}
+
+	var result struct {
+		Data struct {
+			UploadImageUrl string `json:"upload_image_url"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		return cookiepool.CheckError, "unexpected response"
+	}
+	if result.Data.UploadImageUrl == "" {
+		// 未登录时接口仍返回 200, 但不会下发上传地址
+		return cookiepool.CheckExpired, "not logged in"
+	}
+	return cookiepool.CheckValid, ""
+}
+
+// dailyLimitKeywords 上游生图额度用尽时响应中出现的关键字
+var dailyLimitKeywords = []string{"daily limit", "limit reached", "reached your limit", "quota"}
+
+// recordImageQuota 根据生图响应记录账号的每日额度状态, 返回是否已达到额度
+func recordImageQuota(account cookiepool.Account, body string, taskIDs []string) bool {
+	if len(taskIDs) > 0 {
+		cookiepool.SetDailyLimit(account.ID, false)
+		return false
+	}
+	lowerBody := strings.ToLower(body)
+	for _, keyword := range dailyLimitKeywords {
+		if strings.Contains(lowerBody, keyword) {
+			cookiepool.SetDailyLimit(account.ID, true)
+			return true
+		}
+	}
+	return false
+}
+
+// StartCookieProbe 启动对已禁用 cookie 的定期探测、对所有 cookie 的定期校验及代理健康检查
+func StartCookieProbe() {
+	cookiepool.StartProbe(cookiepool.IDs, checkCookie)
+	cookiepool.StartValidation(cookiepool.IDs, checkCookie)
+	cookiepool.StartProxyCheck(checkProxy)
+}
+
+// CheckTokens 主动校验 token, 请求体指定 id 时只校验该 token
+func (t *TokenController) CheckTokens(c *gin.Context) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	var ids []string
+	for _, token := range cookiepool.Tokens() {
+		if req.ID == "" || token.ID == req.ID {
+			ids = append(ids, token.ID)
+		}
+	}
+	if req.ID != "" && len(ids) == 0 {
+		tokenErrorResponse(c, cookiepool.ErrTokenNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "校验完成",
+		"data":    cookiepool.CheckAll(ids, checkCookie),
+	})
+}
+
+// GetTokenHealth 查看 cookie 池的健康状态
+func (t *TokenController) GetTokenHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    cookiepool.Snapshot(cookiepool.IDs()),
+	})
+}
+
+// GetQueueStats 查看请求队列深度及等待时间
+func (t *TokenController) GetQueueStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    cookiepool.GetQueueStats(),
+	})
+}
+
+// GetProxyHealth 查看上游代理的健康状态
+func (t *TokenController) GetProxyHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    cookiepool.ProxySnapshot(),
+	})
+}
+
+// GetProfiles 查看可用的浏览器指纹配置
+func (t *TokenController) GetProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data": gin.H{
+			"default":  config.BrowserProfile,
+			"profiles": fingerprint.Names(),
+		},
+	})
+}
+
+// GetUpstreamStats 查看上游连接池统计
+func (t *TokenController) GetUpstreamStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    upstream.Shared().Stats(),
+	})
+}
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/common/cookiepool"
+	"genspark2api/common/upstream"
+	"github.com/deanxv/CycleTLS/cycletls"
+	"net/http"
+	neturl "net/url"
+)
+
+// GensparkClient 访问 genspark 上游的接口, 测试时可替换为假实现或指向本地服务的实现
+type GensparkClient interface {
+	// Ask 发送对话/生图请求, accept 为期望的响应类型
+	Ask(ctx context.Context, account cookiepool.Account, body []byte, accept string) (cycletls.Response, error)
+	// AskStream 以 SSE 方式发送对话请求
+	AskStream(ctx context.Context, account cookiepool.Account, body []byte) (<-chan cycletls.SSEResponse, error)
+	// DeleteProject 删除上游会话
+	DeleteProject(ctx context.Context, account cookiepool.Account, projectID string) (cycletls.Response, error)
+	// GetUploadURL 获取私有文件上传地址
+	GetUploadURL(ctx context.Context, account cookiepool.Account) (cycletls.Response, error)
+	// Upload 将文件上传到 GetUploadURL 返回的地址
+	Upload(ctx context.Context, account cookiepool.Account, uploadURL string, data []byte) (cycletls.Response, error)
+	// TaskStatus 查询生图任务状态
+	TaskStatus(ctx context.Context, account cookiepool.Account, taskID string) (cycletls.Response, error)
+	// Download 下载生成的图片, 大小不超过 FETCH_MAX_BYTES
+	Download(ctx context.Context, account cookiepool.Account, url string) ([]byte, error)
+	// CheckProxy 通过代理访问上游首页, 用于检查代理是否可用
+	CheckProxy(ctx context.Context, proxy string) (cycletls.Response, error)
+}
+
+// genspark 控制器使用的上游客户端
+var genspark GensparkClient = NewGensparkClient(upstream.Shared(), config.GensparkBaseURL)
+
+// SetGensparkClient 替换控制器使用的上游客户端, 需在处理请求前调用
+func SetGensparkClient(client GensparkClient) {
+	genspark = client
+}
+
+// gensparkClient 基于共享连接池的默认实现
+type gensparkClient struct {
+	client  *upstream.Client
+	baseURL string
+}
+
+// NewGensparkClient 创建访问 baseURL 的上游客户端
+func NewGensparkClient(client *upstream.Client, baseURL string) GensparkClient {
+	return &gensparkClient{client: client, baseURL: baseURL}
+}
+
+func (g *gensparkClient) Ask(ctx context.Context, account cookiepool.Account, body []byte, accept string) (cycletls.Response, error) {
+	return g.client.Do(ctx, g.baseURL+"/api/copilot/ask", g.options(account, cycletls.Options{
+		Timeout: 10 * 60 * 60,
+		Body:    string(body),
+		Method:  "POST",
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Accept":       accept,
+		},
+	}), "POST")
+}
+
+func (g *gensparkClient) AskStream(ctx context.Context, account cookiepool.Account, body []byte) (<-chan cycletls.SSEResponse, error) {
+	return g.client.DoSSE(ctx, g.baseURL+"/api/copilot/ask", g.options(account, cycletls.Options{
+		Timeout: 10 * 60 * 60,
+		Body:    string(body),
+		Method:  "POST",
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Accept":       "text/event-stream",
+		},
+	}), "POST")
+}
+
+func (g *gensparkClient) DeleteProject(ctx context.Context, account cookiepool.Account, projectID string) (cycletls.Response, error) {
+	return g.client.Do(ctx, g.baseURL+"/api/project/delete?project_id="+neturl.QueryEscape(projectID), g.options(account, cycletls.Options{
+		Timeout: 10 * 60 * 60,
+		Method:  "GET",
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Accept":       "application/json",
+		},
+	}), "GET")
+}
+
+func (g *gensparkClient) GetUploadURL(ctx context.Context, account cookiepool.Account) (cycletls.Response, error) {
+	return g.client.Do(ctx, g.baseURL+"/api/get_upload_personal_image_url", g.options(account, cycletls.Options{
+		Timeout: 30,
+		Method:  "GET",
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Accept":       "*/*",
+		},
+	}), "GET")
+}
+
+func (g *gensparkClient) Upload(ctx context.Context, account cookiepool.Account, uploadURL string, data []byte) (cycletls.Response, error) {
+	return g.client.Do(ctx, uploadURL, g.storageOptions(account, cycletls.Options{
+		Timeout: 30,
+		Method:  "PUT",
+		Body:    string(data),
+		Headers: map[string]string{
+			"Accept":         "*/*",
+			"x-ms-blob-type": "BlockBlob",
+			"Content-Type":   "application/octet-stream",
+			"Content-Length": fmt.Sprintf("%d", len(data)),
+		},
+	}), "PUT")
+}
+
+func (g *gensparkClient) TaskStatus(ctx context.Context, account cookiepool.Account, taskID string) (cycletls.Response, error) {
+	return g.client.Do(ctx, g.baseURL+"/api/spark/image_generation_task_status?task_id="+neturl.QueryEscape(taskID), g.options(account, cycletls.Options{
+		Method: "GET",
+	}), "GET")
+}
+
+func (g *gensparkClient) Download(ctx context.Context, account cookiepool.Account, url string) ([]byte, error) {
+	status, data, err := g.client.Download(ctx, url, g.storageOptions(account, cycletls.Options{
+		Timeout: 60,
+		Method:  "GET",
+		Headers: map[string]string{
+			"Accept":          "image/*,*/*",
+			"Accept-Encoding": "identity",
+		},
+	}), int64(config.FetchMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", status)
+	}
+	return data, nil
+}
+
+func (g *gensparkClient) CheckProxy(ctx context.Context, proxy string) (cycletls.Response, error) {
+	options := cycletls.Options{
+		Timeout: 15,
+		Method:  "GET",
+		Proxy:   proxy,
+	}
+	cookiepool.ProfileFor("").Apply(&options)
+	return g.client.Do(ctx, g.baseURL, options, "GET")
+}
+
+// options 构建发往 genspark 的请求参数, 统一设置 cookie、来源、代理及 cookie 绑定的浏览器指纹,
+// 使同一账号的所有请求保持一致的指纹和出口
+func (g *gensparkClient) options(account cookiepool.Account, options cycletls.Options) cycletls.Options {
+	headers := map[string]string{
+		"Origin":         g.baseURL,
+		"Referer":        g.baseURL + "/",
+		"Cookie":         account.Cookie(),
+		"Sec-Fetch-Dest": "empty",
+		"Sec-Fetch-Mode": "cors",
+		"Sec-Fetch-Site": "same-origin",
+	}
+	for key, value := range options.Headers {
+		headers[key] = value
+	}
+	options.Headers = headers
+	options.Proxy = account.Proxy()
+	account.Profile().Apply(&options)
+	return options
+}
+
+// storageOptions 构建发往上传存储的请求参数, 与上传地址所属的账号使用相同的代理及指纹
+func (g *gensparkClient) storageOptions(account cookiepool.Account, options cycletls.Options) cycletls.Options {
+	headers := map[string]string{
+		"Origin":         g.baseURL,
+		"Sec-Fetch-Dest": "empty",
+		"Sec-Fetch-Mode": "cors",
+		"Sec-Fetch-Site": "cross-site",
+	}
+	for key, value := range options.Headers {
+		headers[key] = value
+	}
+	options.Headers = headers
+	options.Proxy = account.Proxy()
+	account.Profile().Apply(&options)
+	return options
+}
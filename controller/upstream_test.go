@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+	"genspark2api/common/cookiepool"
+	"genspark2api/common/upstream"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestGensparkClientBaseURL 上游地址指向本地服务(与设置 GENSPARK_BASE_URL 相同)时, 请求发往该服务并带上账号的 cookie
+func TestGensparkClientBaseURL(t *testing.T) {
+	type request struct {
+		method, uri, cookie, origin, accept, body string
+	}
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.Method, r.RequestURI, r.Header.Get("Cookie"), r.Header.Get("Origin"), r.Header.Get("Accept"), string(body)}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":0}`))
+	}))
+	defer server.Close()
+
+	cookiepool.SetCookies([]string{"session_id=local"})
+	defer cookiepool.SetCookies(nil)
+	account := cookiepool.AccountFor(cookiepool.IDs()[0])
+
+	client := upstream.NewClient(time.Minute)
+	defer client.Close(context.Background())
+	g := NewGensparkClient(client, server.URL)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		want request
+	}{
+		{
+			name: "ask",
+			call: func() error {
+				_, err := g.Ask(ctx, account, []byte(`{"type":"COPILOT_MOA_CHAT"}`), "application/json")
+				return err
+			},
+			want: request{"POST", "/api/copilot/ask", "session_id=local", server.URL, "application/json", `{"type":"COPILOT_MOA_CHAT"}`},
+		},
+		{
+			name: "delete project",
+			call: func() error {
+				_, err := g.DeleteProject(ctx, account, "p 1")
+				return err
+			},
+			want: request{"GET", "/api/project/delete?project_id=p+1", "session_id=local", server.URL, "application/json", ""},
+		},
+		{
+			name: "task status",
+			call: func() error {
+				_, err := g.TaskStatus(ctx, account, "t1")
+				return err
+			},
+			want: request{"GET", "/api/spark/image_generation_task_status?task_id=t1", "session_id=local", server.URL, "", ""},
+		},
+		{
+			name: "check proxy",
+			call: func() error {
+				resp, err := g.CheckProxy(ctx, "")
+				if err == nil && resp.Status != http.StatusOK {
+					t.Errorf("CheckProxy status %d", resp.Status)
+				}
+				return err
+			},
+			// 检查代理不带任何账号的 cookie
+			want: request{"GET", "/", "", "", "", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); err != nil {
+				t.Fatal(err)
+			}
+			got := <-requests
+			if tt.want.accept == "" {
+				got.accept = ""
+			}
+			if got != tt.want {
+				t.Fatalf("server got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckProxy(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusForbidden, false},
+		{http.StatusProxyAuthRequired, true},
+	}
+	for _, tt := range tests {
+		fake := &fakeGenspark{proxyStatus: tt.status}
+		useFakeGenspark(t, fake)
+		err := checkProxy("http://127.0.0.1:8080")
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("status %d: checkProxy() = %v, want error %v", tt.status, err, tt.wantErr)
+		}
+		if len(fake.proxies) != 1 || fake.proxies[0] != "http://127.0.0.1:8080" {
+			t.Fatalf("checked proxies %v", fake.proxies)
+		}
+	}
+}
@@ -1,23 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"genspark2api/check"
 	"genspark2api/common"
 	"genspark2api/common/config"
+	"genspark2api/common/cookiepool"
+	"genspark2api/common/fingerprint"
 	logger "genspark2api/common/loggger"
+	"genspark2api/common/storage"
+	"genspark2api/common/upstream"
+	"genspark2api/controller"
 	"genspark2api/middleware"
 	"genspark2api/router"
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 )
 
 func main() {
+	common.ParseFlags()
 	logger.SetupLogger()
 	logger.SysLog(fmt.Sprintf("genspark2api %s started", common.Version))
 
 	check.CheckEnvVariable()
+	common.LoadTokenizer()
 
 	if os.Getenv("GIN_MODE") != "debug" {
 		gin.SetMode(gin.ReleaseMode)
@@ -25,9 +38,46 @@ func main() {
 	if config.DebugEnabled {
 		logger.SysLog("running in debug mode")
 	}
-	var err error
+	if config.LocalStorageEnable {
+		storage.StartCleanup()
+	}
+	if config.ProxyURL != "" {
+		if err := cookiepool.ValidateProxy(config.ProxyURL); err != nil {
+			logger.FatalLog("invalid PROXY_URL: " + err.Error())
+		}
+	}
+	if config.BrowserProfilesFile != "" {
+		if err := fingerprint.LoadFile(config.BrowserProfilesFile); err != nil {
+			logger.FatalLog("failed to load browser profiles: " + err.Error())
+		}
+	}
+	if _, ok := fingerprint.Get(config.BrowserProfile); !ok {
+		logger.FatalLog("unknown BROWSER_PROFILE: " + config.BrowserProfile)
+	}
+	tokenStore, err := cookiepool.OpenStore()
+	if err != nil {
+		logger.FatalLog("failed to open token store: " + err.Error())
+	}
+	defer tokenStore.Close()
+	if *common.RotateTokenKey {
+		count, err := cookiepool.RotateKey(tokenStore)
+		if err != nil {
+			logger.FatalLog("failed to rotate token key: " + err.Error())
+		}
+		logger.SysLog(fmt.Sprintf("re-encrypted %d tokens with the current key", count))
+		return
+	}
+	if err := cookiepool.Use(tokenStore, cookiepool.SourceCookies()); err != nil {
+		logger.FatalLog("failed to load tokens: " + err.Error())
+	}
+	cookiepool.Watch(time.Duration(config.TokenWatchInterval) * time.Second)
+	controller.StartCookieProbe()
 
 	server := gin.New()
+	// 未配置信任的代理时忽略 X-Forwarded-For, 避免伪造客户端IP绕过登录锁定和限流
+	if err := server.SetTrustedProxies(config.TrustedProxies); err != nil {
+		logger.FatalLog("invalid TRUSTED_PROXIES: " + err.Error())
+	}
 	server.Use(gin.Recovery())
 	server.Use(middleware.RequestId())
 	middleware.SetUpLogger(server)
@@ -37,8 +87,24 @@ func main() {
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
 	}
-	err = server.Run(":" + port)
-	if err != nil {
-		logger.FatalLog("failed to start HTTP server: " + err.Error())
+	httpServer := &http.Server{Addr: ":" + port, Handler: server}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.FatalLog("failed to start HTTP server: " + err.Error())
+		}
+	}()
+
+	// 收到退出信号后停止接收新请求, 等待进行中的请求完成后关闭上游连接
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	logger.SysLog("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.SysError("failed to shut down HTTP server: " + err.Error())
+	}
+	if err := upstream.Shared().Close(shutdownCtx); err != nil {
+		logger.SysError("failed to close upstream client: " + err.Error())
 	}
 }
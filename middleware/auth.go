@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"genspark2api/common/adminauth"
 	"genspark2api/common/config"
 	"genspark2api/model"
 	"github.com/gin-gonic/gin"
@@ -61,3 +62,43 @@ func OpenAIAuth() func(c *gin.Context) {
 		authHelperForOpenai(c)
 	}
 }
+
+// adminSessionToken 从 Authorization 头或会话 cookie 中取出管理会话令牌
+func adminSessionToken(c *gin.Context) (token string, fromCookie bool) {
+	if header := c.Request.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer "), false
+	}
+	token, _ = c.Cookie(adminauth.CookieName)
+	return token, true
+}
+
+func adminAuthHelper(c *gin.Context) {
+	token, fromCookie := adminSessionToken(c)
+	if token == "" || !adminauth.VerifySession(token) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    http.StatusUnauthorized,
+			"message": "未登录或登录已过期",
+			"data":    nil,
+		})
+		c.Abort()
+		return
+	}
+	// 浏览器会自动携带 cookie, 修改类请求需校验 CSRF 令牌; Bearer 令牌不受 CSRF 影响
+	safeMethod := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions
+	if fromCookie && !safeMethod && !adminauth.CheckCSRF(token, c.Request.Header.Get("X-CSRF-Token")) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "CSRF 校验失败",
+			"data":    nil,
+		})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+func AdminAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		adminAuthHelper(c)
+	}
+}
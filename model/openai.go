@@ -4,6 +4,7 @@ type OpenAIChatCompletionRequest struct {
 	Model    string              `json:"model"`
 	Stream   bool                `json:"stream"`
 	Messages []OpenAIChatMessage `json:"messages"`
+	User     string              `json:"user"`
 	OpenAIChatCompletionExtraRequest
 }
 
@@ -67,6 +68,7 @@ type OpenAIImagesGenerationRequest struct {
 	Model          string `json:"model"`
 	Prompt         string `json:"prompt"`
 	ResponseFormat string `json:"response_format"`
+	User           string `json:"user"`
 }
 
 type OpenAIImagesGenerationResponse struct {
@@ -108,6 +110,27 @@ type OpenAIModerationResponse struct {
 	} `json:"results"`
 }
 
+type OpenAIFile struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+}
+
+type OpenAIFileListResponse struct {
+	Object string       `json:"object"`
+	Data   []OpenAIFile `json:"data"`
+}
+
+type OpenAIFileDeleteResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
 type OpenaiModelResponse struct {
 	ID     string `json:"id"`
 	Object string `json:"object"`
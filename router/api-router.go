@@ -17,15 +17,51 @@ func SetApiRouter(router *gin.Engine) {
     v1Router.POST("/chat/completions", controller.ChatForOpenAI)
     v1Router.POST("/images/generations", controller.ImagesForOpenAI)
     v1Router.GET("/models", controller.OpenaiModels)
+    v1Router.POST("/files", controller.UploadFile)
+    v1Router.GET("/files", controller.ListFiles)
+    v1Router.GET("/files/:file_id", controller.RetrieveFile)
+    v1Router.DELETE("/files/:file_id", controller.DeleteFile)
+
+    // 本地存储文件访问(签名校验)
+    router.GET("/files/:name", controller.ServeFile)
 
     // token 相关路由
     tokenController := &controller.TokenController{}
-    
-    // 网页路由
-    router.GET("/:password", tokenController.TokenPage)
-    
+
+    // 管理后台页面及登录
+    router.GET("/admin", tokenController.TokenPage)
+    router.POST("/admin/login", tokenController.Login)
+
+    // 管理接口需登录, 使用会话 cookie 时修改类请求需携带 X-CSRF-Token
+    adminRouter := router.Group("/admin")
+    adminRouter.Use(middleware.AdminAuth())
+    adminRouter.POST("/logout", tokenController.Logout)
+
     // token管理API路由
-    router.GET("/:password/token/list", tokenController.GetTokens)     // 查看所有 token
-    router.POST("/:password/token/append", tokenController.AppendToken) // 追加 token
-    router.POST("/:password/token/clear", tokenController.ClearTokens)  // 清空 token
+    adminRouter.GET("/token/list", tokenController.GetTokens)         // 查看所有 token
+    adminRouter.POST("/token/append", tokenController.AppendToken)    // 追加 token
+    adminRouter.POST("/token/clear", tokenController.ClearTokens)     // 清空 token
+    adminRouter.POST("/token/delete", tokenController.DeleteToken)    // 删除单个 token
+    adminRouter.POST("/token/enable", tokenController.EnableToken)    // 启用 token
+    adminRouter.POST("/token/disable", tokenController.DisableToken)  // 停用 token
+    adminRouter.POST("/token/label", tokenController.LabelToken)      // 设置 token 标签
+    adminRouter.POST("/token/proxy", tokenController.ProxyToken)      // 绑定 token 代理
+    adminRouter.POST("/token/profile", tokenController.ProfileToken)  // 绑定 token 浏览器指纹
+    adminRouter.GET("/token/health", tokenController.GetTokenHealth)  // 查看 token 健康状态
+    adminRouter.POST("/token/check", tokenController.CheckTokens)     // 主动校验 token
+
+    // 可用的浏览器指纹配置
+    adminRouter.GET("/profiles", tokenController.GetProfiles)
+
+    // 上游代理健康状态
+    adminRouter.GET("/proxy/health", tokenController.GetProxyHealth)
+
+    // 上游连接池统计
+    adminRouter.GET("/upstream/stats", tokenController.GetUpstreamStats)
+
+    // 请求队列统计
+    adminRouter.GET("/queue/stats", tokenController.GetQueueStats)
+
+    // 附件上传缓存统计
+    adminRouter.GET("/cache/stats", controller.UploadCacheStats)
 }